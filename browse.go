@@ -3,12 +3,14 @@ package dnssd
 import "unsafe"
 
 // BrowseCallbackFunc is called when an error occurs or a service is lost or found.
+// If the op has a subtype set, serviceType is the parent service type.
 type BrowseCallbackFunc func(op *BrowseOp, err error, add bool, interfaceIndex int, name string, serviceType string, domain string)
 
 // BrowseOp represents a query for services of a particular type.
 type BrowseOp struct {
 	baseOp
 	stype    string
+	subtype  string
 	domain   string
 	callback BrowseCallbackFunc
 }
@@ -45,6 +47,29 @@ func (o *BrowseOp) SetType(s string) error {
 	return nil
 }
 
+// Subtype returns the service subtype associated with the op.
+func (o *BrowseOp) Subtype() string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.subtype
+}
+
+// SetSubtype limits the browse to services registered with the given subtype (eg: "_printer").
+// A subtype can not exceed 63 bytes or contain a period, comma or backslash.
+// An empty string clears the subtype.
+func (o *BrowseOp) SetSubtype(s string) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	if s != "" && !validSubtype(s) {
+		return ErrBadSubtype
+	}
+	o.subtype = s
+	return nil
+}
+
 // Domain returns the domain associated with the op.
 func (o *BrowseOp) Domain() string {
 	o.m.Lock()
@@ -52,14 +77,20 @@ func (o *BrowseOp) Domain() string {
 	return o.domain
 }
 
-// SetDomain sets the domain associated with the op.
+// SetDomain sets the domain associated with the op. An internationalized
+// unicast domain is converted to its IDNA ASCII form. If it can't be, an
+// error is returned and the op's domain is left unchanged.
 func (o *BrowseOp) SetDomain(s string) error {
 	o.m.Lock()
 	defer o.m.Unlock()
 	if o.started {
 		return ErrStarted
 	}
-	o.domain = s
+	d, err := domainToASCII(s)
+	if err != nil {
+		return &ValidationError{FieldDomain, s, ErrBadDomain}
+	}
+	o.domain = d
 	return nil
 }
 
@@ -74,6 +105,26 @@ func (o *BrowseOp) SetCallback(f BrowseCallbackFunc) error {
 	return nil
 }
 
+// ForceMulticast indicates whether the browse will be performed using multicast only.
+func (o *BrowseOp) ForceMulticast() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsForceMulticast != 0
+}
+
+// SetForceMulticast sets whether the browse will be performed using multicast only.
+// If set to true the browse will be performed via multicast DNS even if the domain
+// would normally imply unicast DNS.
+func (o *BrowseOp) SetForceMulticast(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsForceMulticast, e)
+	return nil
+}
+
 // Start begins the browse query.
 func (o *BrowseOp) Start() error {
 	o.m.Lock()
@@ -84,6 +135,11 @@ func (o *BrowseOp) Start() error {
 	if o.callback == nil {
 		return ErrMissingCallback
 	}
+	if !o.skipValidation {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
 	err := pollServer.startOp(o)
 	o.started = err == nil || err == ErrStarted
 	return err
@@ -92,7 +148,13 @@ func (o *BrowseOp) Start() error {
 func (o *BrowseOp) init(sharedref uintptr) (ref uintptr, err error) {
 	ref = sharedref
 	o.setFlag(_FlagsShareConnection, ref != 0)
-	if err = browseStart(&ref, o.flags, o.interfaceIndexC(), o.stype, o.domain, unsafe.Pointer(o)); err != nil {
+	// Both Bonjour and Avahi accept subtypes in the "_sub" form when browsing, eg:
+	// "_printer._sub._http._tcp".
+	stype := o.stype
+	if o.subtype != "" {
+		stype = o.subtype + "._sub." + stype
+	}
+	if err = browseStart(&ref, o.flags, o.interfaceIndexC(), stype, o.domain, unsafe.Pointer(o)); err != nil {
 		ref = 0
 	}
 	return
@@ -114,7 +176,7 @@ func (o *BrowseOp) handleError(e error) {
 		return
 	}
 	o.started = false
-	pollServer.removePollOp(o)
+	pollServer.failOp(o, e)
 	queueCallback(func() { o.callback(o, e, false, 0, "", "", "") })
 }
 
@@ -123,10 +185,11 @@ func dnssdBrowseCallback(sdRef unsafe.Pointer, flags, interfaceIndex uint32, err
 	if e := getError(err); e != nil {
 		o.handleError(e)
 	} else {
+		pollServer.countResult(o)
 		a := flags&_FlagsAdd != 0
-		i := int(interfaceIndex)
+		i := interfaceIndexGo(interfaceIndex)
 		n := cStringToString(name)
-		t := cStringToString(stype)
+		t := parentServiceType(cStringToString(stype))
 		d := cStringToString(domain)
 		queueCallback(func() { o.callback(o, nil, a, i, n, t, d) })
 	}
@@ -0,0 +1,271 @@
+package dnssd
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a resource record returned by a query.
+type Record struct {
+	Name           string
+	Type           uint16
+	Class          uint16
+	InterfaceIndex int
+	Data           []byte
+	TTL            uint32
+	// Received is the time the record was last added or refreshed.
+	Received time.Time
+}
+
+// Expires returns the time after which the record should be discarded if
+// it is no longer being monitored by a query.
+func (r Record) Expires() time.Time {
+	return r.Received.Add(time.Duration(r.TTL) * time.Second)
+}
+
+// RecordWatchCallbackFunc is called when a record is added to or removed from a watched RRset, or when an error occurs.
+type RecordWatchCallbackFunc func(w *RecordWatch, err error, add bool, r Record)
+
+// RecordCache stores RRsets obtained from queries keyed by name, type and class.
+//
+// Watching an RRset starts a QueryOp which is shared by all watchers of that
+// RRset and stopped when the last watcher stops. While a query is active its
+// records remain valid until the daemon removes them. Once no query is active
+// records are discarded after their TTL has elapsed; expired records are
+// swept from the whole cache whenever it's accessed.
+type RecordCache struct {
+	m       sync.Mutex
+	now     func() time.Time
+	entries map[recordKey]*cacheEntry
+}
+
+type recordKey struct {
+	name            string
+	rrtype, rrclass uint16
+}
+
+type cacheEntry struct {
+	key      recordKey
+	op       *QueryOp
+	records  []Record
+	watchers []*RecordWatch
+}
+
+// RecordWatch represents a subscription to an RRset held by a RecordCache.
+type RecordWatch struct {
+	c        *RecordCache
+	e        *cacheEntry
+	callback RecordWatchCallbackFunc
+}
+
+// NewRecordCache creates a new, empty RecordCache.
+func NewRecordCache() *RecordCache {
+	return &RecordCache{now: time.Now, entries: make(map[recordKey]*cacheEntry)}
+}
+
+// SetClock sets the function used to obtain the current time. It defaults to time.Now.
+func (c *RecordCache) SetClock(now func() time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = now
+}
+
+func newRecordKey(name string, rrtype, rrclass uint16) recordKey {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return recordKey{name, rrtype, rrclass}
+}
+
+// Get returns the cached records for the given name, type and class.
+func (c *RecordCache) Get(name string, rrtype, rrclass uint16) []Record {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.sweep()
+	e := c.entries[newRecordKey(name, rrtype, rrclass)]
+	if e == nil {
+		return nil
+	}
+	return copyRecords(e.records)
+}
+
+// Watch subscribes to changes to the RRset with the given name, type and class.
+// Records already cached are passed to the callback before any changes.
+func (c *RecordCache) Watch(name string, rrtype, rrclass uint16, f RecordWatchCallbackFunc) (*RecordWatch, error) {
+	if f == nil {
+		return nil, ErrMissingCallback
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.sweep()
+	key := newRecordKey(name, rrtype, rrclass)
+	e := c.entries[key]
+	if e == nil {
+		e = &cacheEntry{key: key}
+		c.entries[key] = e
+	}
+	if e.op == nil {
+		op := NewQueryOp(InterfaceIndexAny, name, rrtype, rrclass, nil)
+		op.SetCallback(func(op *QueryOp, err error, add bool, interfaceIndex int, fullname string, rrtype, rrclass uint16, rdata []byte, ttl uint32) {
+			c.queryCallback(e, op, err, add, interfaceIndex, fullname, rdata, ttl)
+		})
+		if err := op.Start(); err != nil {
+			if len(e.watchers) == 0 && len(e.records) == 0 {
+				delete(c.entries, key)
+			}
+			return nil, err
+		}
+		e.op = op
+	}
+	w := &RecordWatch{c: c, e: e, callback: f}
+	e.watchers = append(e.watchers, w)
+	records := copyRecords(e.records)
+	queueCallback(func() {
+		for _, r := range records {
+			if !w.Active() {
+				return
+			}
+			w.callback(w, nil, true, r)
+		}
+	})
+	return w, nil
+}
+
+// Active indicates whether the watch is active.
+func (w *RecordWatch) Active() bool {
+	w.c.m.Lock()
+	defer w.c.m.Unlock()
+	return w.active()
+}
+
+func (w *RecordWatch) active() bool {
+	for _, x := range w.e.watchers {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop stops the watch. The underlying query is stopped once it has no watchers.
+func (w *RecordWatch) Stop() {
+	w.c.m.Lock()
+	defer w.c.m.Unlock()
+	e := w.e
+	for i, x := range e.watchers {
+		if x == w {
+			e.watchers = append(e.watchers[:i:i], e.watchers[i+1:]...)
+			break
+		}
+	}
+	if len(e.watchers) == 0 && e.op != nil {
+		e.op.Stop()
+		e.op = nil
+		// Records now age out from the time the query stopped.
+		now := w.c.now()
+		for i := range e.records {
+			e.records[i].Received = now
+		}
+	}
+}
+
+// sweep expires every entry.
+func (c *RecordCache) sweep() {
+	for _, e := range c.entries {
+		c.expire(e)
+	}
+}
+
+// expire discards records that have outlived their TTL, and the entry itself
+// once it's empty, if no query is active.
+func (c *RecordCache) expire(e *cacheEntry) {
+	if e.op != nil {
+		return
+	}
+	now := c.now()
+	records := e.records[:0]
+	for _, r := range e.records {
+		if now.Before(r.Expires()) {
+			records = append(records, r)
+		}
+	}
+	e.records = records
+	if len(e.records) == 0 && len(e.watchers) == 0 && c.entries[e.key] == e {
+		delete(c.entries, e.key)
+	}
+}
+
+// apply adds or removes a record, returning it and whether the RRset changed.
+func (e *cacheEntry) apply(add bool, interfaceIndex int, fullname string, rdata []byte, ttl uint32, now time.Time) (Record, bool) {
+	r := Record{
+		Name:           fullname,
+		Type:           e.key.rrtype,
+		Class:          e.key.rrclass,
+		InterfaceIndex: interfaceIndex,
+		Data:           rdata,
+		TTL:            ttl,
+		Received:       now,
+	}
+	for i, x := range e.records {
+		if x.InterfaceIndex != interfaceIndex || !bytes.Equal(x.Data, rdata) {
+			continue
+		}
+		if add {
+			e.records[i].TTL, e.records[i].Received = ttl, now
+			return r, false
+		}
+		e.records = append(e.records[:i:i], e.records[i+1:]...)
+		return x, true
+	}
+	if !add {
+		return r, false
+	}
+	e.records = append(e.records, r)
+	return r, true
+}
+
+func (c *RecordCache) queryCallback(e *cacheEntry, op *QueryOp, err error, add bool, interfaceIndex int, fullname string, rdata []byte, ttl uint32) {
+	c.m.Lock()
+	if e.op != op {
+		c.m.Unlock()
+		return
+	}
+	watchers := append([]*RecordWatch(nil), e.watchers...)
+	var r Record
+	if err != nil {
+		e.op = nil
+		e.watchers = nil
+		now := c.now()
+		for i := range e.records {
+			e.records[i].Received = now
+		}
+	} else {
+		var changed bool
+		if r, changed = e.apply(add, interfaceIndex, fullname, rdata, ttl, c.now()); !changed {
+			watchers = nil
+		}
+		r.Data = append([]byte(nil), r.Data...)
+	}
+	c.m.Unlock()
+	for _, w := range watchers {
+		if err == nil && !w.Active() {
+			continue
+		}
+		w.callback(w, err, add, r)
+	}
+}
+
+func copyRecords(records []Record) []Record {
+	if len(records) == 0 {
+		return nil
+	}
+	c := make([]Record, len(records))
+	for i, r := range records {
+		c[i] = r
+		c[i].Data = append([]byte(nil), r.Data...)
+	}
+	return c
+}
@@ -0,0 +1,336 @@
+// Command dnssd is a dns-sd style tool for registering, browsing, resolving
+// and querying DNS-SD services using the dnssd package. Each mode maps onto
+// one op and runs until interrupted, printing a timestamped line per result.
+//
+// Usage:
+//
+//	dnssd [-i index] -B <Type> [<Domain>]
+//	dnssd [-i index] -R <Name> <Type> <Domain> <Port> [<TXT>...]
+//	dnssd [-i index] -P <Name> <Type> <Domain> <Port> <Host> [<TXT>...]
+//	dnssd [-i index] -L <Name> <Type> [<Domain>]
+//	dnssd [-i index] -Q <Name> [<Type> [<Class>]]
+//	dnssd [-i index] -E
+//	dnssd [-i index] -F
+//
+// TXT arguments are of the form "key=value", or "key" for a key without a
+// value. A domain of "" or "." uses the default domains. Proxy registrations
+// (-P) advertise an existing host; its address records aren't registered.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewtj/dnssd"
+	"github.com/andrewtj/dnssd/rr"
+)
+
+type op interface {
+	Start() error
+	Stop()
+}
+
+var errUsage = errors.New("usage")
+
+var (
+	interfaceIndex = flag.Int("i", dnssd.InterfaceIndexAny, "interface `index` to use")
+	modes          = []struct {
+		set *bool
+		f   func(args []string) (op, error)
+	}{
+		{flag.Bool("B", false, "browse for services"), browse},
+		{flag.Bool("R", false, "register a service"), register},
+		{flag.Bool("P", false, "register a service on behalf of another host"), proxyRegister},
+		{flag.Bool("L", false, "resolve a service instance"), resolve},
+		{flag.Bool("Q", false, "query for a record"), query},
+		{flag.Bool("E", false, "enumerate registration domains"), registrationDomains},
+		{flag.Bool("F", false, "enumerate browse domains"), browseDomains},
+	}
+	errc = make(chan error, 1)
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	var start func(args []string) (op, error)
+	for _, m := range modes {
+		if *m.set {
+			if start != nil {
+				usage()
+			}
+			start = m.f
+		}
+	}
+	if start == nil {
+		usage()
+	}
+	o, err := start(flag.Args())
+	if err == errUsage {
+		usage()
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if err := o.Start(); err != nil {
+		fatal(err)
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	select {
+	case <-sig:
+		o.Stop()
+	case err := <-errc:
+		o.Stop()
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage:
+  dnssd [-i index] -B <Type> [<Domain>]
+  dnssd [-i index] -R <Name> <Type> <Domain> <Port> [<TXT>...]
+  dnssd [-i index] -P <Name> <Type> <Domain> <Port> <Host> [<TXT>...]
+  dnssd [-i index] -L <Name> <Type> [<Domain>]
+  dnssd [-i index] -Q <Name> [<Type> [<Class>]]
+  dnssd [-i index] -E
+  dnssd [-i index] -F
+`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "dnssd: %v\n", err)
+	os.Exit(1)
+}
+
+// report prints a line prefixed with the current time.
+func report(format string, a ...interface{}) {
+	fmt.Printf("%s  %s\n", time.Now().Format("15:04:05.000"), fmt.Sprintf(format, a...))
+}
+
+// fail passes the first error reported by an op to main.
+func fail(err error) {
+	select {
+	case errc <- err:
+	default:
+	}
+}
+
+func action(add bool) string {
+	if add {
+		return "Add"
+	}
+	return "Rmv"
+}
+
+// optional returns args[i] or "" if there aren't enough args.
+func optional(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+func defaultDomain(s string) string {
+	if s == "." {
+		return ""
+	}
+	return s
+}
+
+func browse(args []string) (op, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errUsage
+	}
+	o := dnssd.NewBrowseOp(args[0], func(op *dnssd.BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+		if err != nil {
+			fail(err)
+			return
+		}
+		report("%s  if=%-3d %-24s %-24s %s", action(add), interfaceIndex, domain, serviceType, name)
+	})
+	o.SetInterfaceIndex(*interfaceIndex)
+	return o, o.SetDomain(defaultDomain(optional(args, 1)))
+}
+
+func register(args []string) (op, error) {
+	if len(args) < 4 {
+		return nil, errUsage
+	}
+	return newRegisterOp(args[0], args[1], args[2], args[3], "", args[4:])
+}
+
+func proxyRegister(args []string) (op, error) {
+	if len(args) < 5 {
+		return nil, errUsage
+	}
+	return newRegisterOp(args[0], args[1], args[2], args[3], args[4], args[5:])
+}
+
+func newRegisterOp(name, serviceType, domain, port, host string, txt []string) (op, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	f := func(op *dnssd.RegisterOp, err error, add bool, name, serviceType, domain string) {
+		if err != nil {
+			fail(err)
+			return
+		}
+		report("%s  %-24s %-24s %s", action(add), domain, serviceType, name)
+	}
+	var o *dnssd.RegisterOp
+	if host != "" {
+		o = dnssd.NewProxyRegisterOp(name, serviceType, host, p, f)
+	} else {
+		o = dnssd.NewRegisterOp(name, serviceType, p, f)
+	}
+	o.SetInterfaceIndex(*interfaceIndex)
+	if err := o.SetDomain(defaultDomain(domain)); err != nil {
+		return nil, err
+	}
+	r, err := parseTXT(txt)
+	if err != nil {
+		return nil, err
+	}
+	return o, o.SetTXT(r)
+}
+
+func resolve(args []string) (op, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errUsage
+	}
+	domain := defaultDomain(optional(args, 2))
+	if domain == "" {
+		domain = "local."
+	}
+	o := dnssd.NewResolveOp(*interfaceIndex, args[0], args[1], domain, nil)
+	o.SetResultCallback(func(op *dnssd.ResolveOp, err error, r dnssd.ResolveResult) {
+		if err != nil {
+			fail(err)
+			return
+		}
+		report("if=%-3d %s can be reached at %s:%d %s", r.InterfaceIndex, r.FullName, r.Host, r.Port, formatTXT(r.TXT))
+	})
+	return o, nil
+}
+
+func query(args []string) (op, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, errUsage
+	}
+	rrtype, rrclass := rr.TypeA, rr.ClassINET
+	var err error
+	if len(args) > 1 {
+		if rrtype, err = parseType(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) > 2 {
+		if rrclass, err = parseClass(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	o := dnssd.NewQueryOp(*interfaceIndex, "", rrtype, rrclass, nil)
+	o.SetEventCallback(func(op *dnssd.QueryOp, err error, e dnssd.QueryEvent) {
+		if err != nil {
+			fail(err)
+			return
+		}
+		rdata := "(no such record)"
+		if e.RData != nil {
+			rdata = e.RData.String()
+		}
+		report("%s  if=%-3d %s %s %d %s", action(e.Add), e.InterfaceIndex, e.Name, rr.TypeString(e.Type), e.TTL, rdata)
+	})
+	return o, o.SetName(args[0])
+}
+
+func registrationDomains(args []string) (op, error) {
+	return enumDomains(args, true)
+}
+
+func browseDomains(args []string) (op, error) {
+	return enumDomains(args, false)
+}
+
+func enumDomains(args []string, registration bool) (op, error) {
+	if len(args) != 0 {
+		return nil, errUsage
+	}
+	o := dnssd.NewDomainEnumOp(func(op *dnssd.DomainEnumOp, err error, add bool, interfaceIndex int, domain string, isDefault bool) {
+		if err != nil {
+			fail(err)
+			return
+		}
+		def := ""
+		if isDefault {
+			def = " (default)"
+		}
+		report("%s  if=%-3d %s%s", action(add), interfaceIndex, domain, def)
+	})
+	o.SetInterfaceIndex(*interfaceIndex)
+	return o, o.SetRegistrationDomains(registration)
+}
+
+// parseTXT returns a TXT record containing each "key=value" or "key" argument.
+func parseTXT(args []string) (dnssd.TXTRecord, error) {
+	var r dnssd.TXTRecord
+	for _, s := range args {
+		var value []byte
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			s, value = s[:i], []byte(s[i+1:])
+		}
+		if err := r.Set(s, value); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func formatTXT(r dnssd.TXTRecord) string {
+	s := make([]string, len(r))
+	for i, e := range r {
+		s[i] = e.Key
+		if !e.Bool() {
+			s[i] += "=" + strconv.Quote(string(e.Value))
+		}
+	}
+	return strings.Join(s, " ")
+}
+
+// parseType parses a record type given as a mnemonic, a number or in the
+// generic form "TYPE65534".
+func parseType(s string) (uint16, error) {
+	u := strings.ToUpper(s)
+	for _, t := range []uint16{rr.TypeA, rr.TypeCNAME, rr.TypePTR, rr.TypeHINFO, rr.TypeTXT, rr.TypeAAAA, rr.TypeSRV, rr.TypeNSEC} {
+		if rr.TypeString(t) == u {
+			return t, nil
+		}
+	}
+	if u == "ANY" {
+		return 255, nil
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(u, "TYPE"), 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	return 0, fmt.Errorf("invalid record type %q", s)
+}
+
+// parseClass parses a record class given as "IN" or a number.
+func parseClass(s string) (uint16, error) {
+	if strings.EqualFold(s, "IN") {
+		return rr.ClassINET, nil
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "CLASS"), 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	return 0, fmt.Errorf("invalid record class %q", s)
+}
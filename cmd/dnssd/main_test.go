@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/andrewtj/dnssd/rr"
+)
+
+func TestParseType(t *testing.T) {
+	for s, want := range map[string]uint16{
+		"A":         rr.TypeA,
+		"srv":       rr.TypeSRV,
+		"ANY":       255,
+		"16":        rr.TypeTXT,
+		"TYPE65534": 65534,
+	} {
+		if got, err := parseType(s); err != nil || got != want {
+			t.Fatalf("parseType(%q) returned %d, %v, expected %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "AXFR2", "65536", "TYPE"} {
+		if _, err := parseType(s); err == nil {
+			t.Fatalf("Expected parseType(%q) to fail", s)
+		}
+	}
+	if c, err := parseClass("in"); err != nil || c != rr.ClassINET {
+		t.Fatalf("parseClass(\"in\") returned %d, %v", c, err)
+	}
+}
+
+func TestParseTXT(t *testing.T) {
+	r, err := parseTXT([]string{"path=/", "secure", "empty="})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := formatTXT(r); s != `path="/" secure empty=""` {
+		t.Fatalf("Unexpected TXT record: %s", s)
+	}
+}
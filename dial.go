@@ -0,0 +1,208 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAttemptDelay is the delay between connection attempts recommended by RFC 8305.
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// DefaultResolutionDelay is how long to wait for IPv6 addresses once IPv4
+// addresses are known, as recommended by RFC 8305.
+const DefaultResolutionDelay = 50 * time.Millisecond
+
+// ServiceDialer connects to service instances by name.
+//
+// The zero value is ready to use. Its methods may be called from within a
+// callback, such as to connect to an instance found by a BrowseOp.
+type ServiceDialer struct {
+	// Dialer is used for each connection attempt.
+	Dialer net.Dialer
+	// AttemptDelay is the delay before starting the next connection attempt
+	// while earlier attempts are outstanding. If zero DefaultAttemptDelay is used.
+	AttemptDelay time.Duration
+	// ResolutionDelay is how long to wait for IPv6 addresses before
+	// connecting to IPv4 addresses that arrived first. If zero
+	// DefaultResolutionDelay is used.
+	ResolutionDelay time.Duration
+	// Reconfirm requests that the daemon verify the address records of the
+	// service's host when every connection attempt fails, so that stale
+	// records are flushed.
+	Reconfirm bool
+}
+
+// DialService is the equivalent of calling DialService on a zero ServiceDialer.
+func DialService(ctx context.Context, network, instance, serviceType, domain string) (net.Conn, error) {
+	var d ServiceDialer
+	return d.DialService(ctx, network, instance, serviceType, domain)
+}
+
+// DialService resolves the given service instance and connects to it.
+//
+// The addresses of the instance's host are raced as described in RFC 8305.
+// Connection attempts start as soon as IPv6 addresses arrive, or IPv4
+// addresses once ResolutionDelay passes without IPv6 ones, and alternate
+// between the families as further addresses arrive. The network must be one
+// supported by net.Dialer with IP addresses, such as "tcp", "tcp4" or "tcp6".
+// Link-local IPv6 addresses are scoped to the interface the instance was
+// resolved on.
+func (d *ServiceDialer) DialService(ctx context.Context, network, instance, serviceType, domain string) (net.Conn, error) {
+	res, err := resolveInstance(ctx, InterfaceIndexAny, instance, serviceType, domain)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	r := Resolver{InterfaceIndex: res.InterfaceIndex}
+	lookup := func(ctx context.Context, rrtype uint16) ([]queryResult, error) {
+		return r.lookup(ctx, res.Host, rrtype)
+	}
+	c, results, err := d.dialParallel(ctx, network, lookup, res.Port)
+	if err != nil && d.Reconfirm && ctx.Err() == nil {
+		for _, qr := range results {
+			ReconfirmRecord(qr.interfaceIndex, qr.fullname, qr.rrtype, qr.rrclass, qr.rdata)
+		}
+	}
+	if ae, ok := err.(*net.AddrError); ok {
+		ae.Addr = res.Host
+	}
+	if _, ok := err.(*net.OpError); err != nil && !ok {
+		err = &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	return c, err
+}
+
+// addrTypes returns the address record types to look up for network.
+func addrTypes(network string) []uint16 {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return []uint16{rrTypeA}
+	case strings.HasSuffix(network, "6"):
+		return []uint16{rrTypeAAAA}
+	}
+	return []uint16{rrTypeAAAA, rrTypeA}
+}
+
+// dialParallel looks up the addresses of a host with lookup, one query per
+// address family, and races connection attempts to them as they arrive. The
+// next attempt starts when the previous one fails or AttemptDelay elapses,
+// alternating between families and starting with IPv6. It returns the first
+// connection established along with the addresses that were found.
+func (d *ServiceDialer) dialParallel(ctx context.Context, network string, lookup func(ctx context.Context, rrtype uint16) ([]queryResult, error), port int) (net.Conn, []queryResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	delay := d.AttemptDelay
+	if delay <= 0 {
+		delay = DefaultAttemptDelay
+	}
+	resolutionDelay := d.ResolutionDelay
+	if resolutionDelay <= 0 {
+		resolutionDelay = DefaultResolutionDelay
+	}
+	type lookupResult struct {
+		rrtype  uint16
+		results []queryResult
+		err     error
+	}
+	types := addrTypes(network)
+	lookups := make(chan lookupResult, len(types))
+	for _, t := range types {
+		go func(t uint16) {
+			results, err := lookup(ctx, t)
+			lookups <- lookupResult{t, results, err}
+		}(t)
+	}
+	type attempt struct {
+		c   net.Conn
+		err error
+	}
+	ch := make(chan attempt)
+	var (
+		all, v4, v6 []queryResult
+		preferV6    = true
+		ready       bool // whether attempts may start
+		resTimer    <-chan time.Time
+		timer       <-chan time.Time
+		waiting     = len(types)
+		pending     int
+		lookupErr   error
+		firstErr    error
+	)
+	start := func() {
+		var qr queryResult
+		switch {
+		case len(v6) > 0 && (preferV6 || len(v4) == 0):
+			qr, v6, preferV6 = v6[0], v6[1:], false
+		case len(v4) > 0:
+			qr, v4, preferV6 = v4[0], v4[1:], true
+		default:
+			return
+		}
+		pending++
+		timer = time.After(delay)
+		a := ipAddr(qr.rdata, qr.interfaceIndex)
+		go func() {
+			c, err := d.Dialer.DialContext(ctx, network, net.JoinHostPort(a.String(), strconv.Itoa(port)))
+			select {
+			case ch <- attempt{c, err}:
+			case <-ctx.Done():
+				if c != nil {
+					c.Close()
+				}
+			}
+		}()
+	}
+	for {
+		if ready && timer == nil {
+			start()
+		}
+		if pending == 0 && waiting == 0 && len(v4)+len(v6) == 0 {
+			break
+		}
+		select {
+		case lr := <-lookups:
+			waiting--
+			if lr.err != nil && lookupErr == nil {
+				lookupErr = lr.err
+			}
+			for _, qr := range lr.results {
+				if qr.rrtype != lr.rrtype {
+					continue
+				}
+				all = append(all, qr)
+				if qr.rrtype == rrTypeAAAA {
+					v6 = append(v6, qr)
+				} else {
+					v4 = append(v4, qr)
+				}
+			}
+			if lr.rrtype == rrTypeAAAA || waiting == 0 {
+				ready, resTimer = true, nil
+			} else if !ready && resTimer == nil {
+				resTimer = time.After(resolutionDelay)
+			}
+		case <-resTimer:
+			ready, resTimer = true, nil
+		case <-timer:
+			timer = nil
+		case a := <-ch:
+			pending--
+			if a.err == nil {
+				return a.c, all, nil
+			}
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			timer = nil
+		}
+	}
+	switch {
+	case firstErr != nil:
+		return nil, all, firstErr
+	case len(all) == 0 && lookupErr != nil:
+		return nil, all, lookupErr
+	}
+	return nil, all, &net.AddrError{Err: "no suitable address"}
+}
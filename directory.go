@@ -0,0 +1,493 @@
+package dnssd
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Service describes a resolved service instance.
+type Service struct {
+	Name   string
+	Type   string
+	Domain string
+	// FullName is the escaped full domain name of the service instance.
+	FullName string
+	// InterfaceIndex is the interface the service was resolved on.
+	InterfaceIndex int
+	Host           string
+	Port           int
+	TXT            TXTRecord
+	// Addrs contains the addresses of Host. Link-local IPv6 addresses have
+	// their zone set to the name of the interface they were found on.
+	Addrs []net.IPAddr
+}
+
+func (s Service) copy() Service {
+	s.TXT = s.TXT.Copy()
+	s.Addrs = append([]net.IPAddr(nil), s.Addrs...)
+	return s
+}
+
+// ServiceEventType indicates the kind of change a ServiceEvent describes.
+type ServiceEventType int
+
+// Kinds of ServiceEvent.
+const (
+	ServiceAdded ServiceEventType = iota + 1
+	ServiceUpdated
+	ServiceRemoved
+)
+
+func (t ServiceEventType) String() string {
+	switch t {
+	case ServiceAdded:
+		return "added"
+	case ServiceUpdated:
+		return "updated"
+	case ServiceRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// ServiceChange is a set of flags indicating which parts of a Service changed.
+type ServiceChange uint
+
+// Flags for ServiceChange.
+const (
+	ServiceTXTChanged ServiceChange = 1 << iota
+	ServicePortChanged
+	ServiceHostChanged
+	ServiceAddrsChanged
+)
+
+// ServiceEvent describes a change to the set of services held by a ServiceDirectory.
+type ServiceEvent struct {
+	Type    ServiceEventType
+	Service Service
+	// Changes is set for ServiceUpdated events.
+	Changes ServiceChange
+}
+
+// ServiceEventCallbackFunc is called when a service is added, updated or removed, or when an error occurs.
+type ServiceEventCallbackFunc func(d *ServiceDirectory, err error, e ServiceEvent)
+
+// ServiceDirectory maintains an up-to-date set of resolved services of a particular type.
+//
+// Instances found by browsing are resolved, after which the ResolveOp is
+// stopped and the instance's SRV and TXT records and the addresses of its
+// host are monitored for changes. An instance found on several interfaces is
+// only reported once and is removed when it has been lost on every interface.
+// If it's lost on the interface it was resolved on, it's monitored on one of
+// the others instead and its addresses are found again. A service is added
+// when it has been resolved; its addresses follow as updates.
+type ServiceDirectory struct {
+	m              sync.Mutex
+	started        bool
+	stype          string
+	domain         string
+	interfaceIndex int
+	callback       ServiceEventCallbackFunc
+	browse         *BrowseOp
+	instances      map[string]*dirInstance
+}
+
+type dirInstance struct {
+	name, stype, domain string
+	ifaces              map[int]bool
+	resolved            bool
+	svc                 Service
+	resolve             *ResolveOp
+	srv, txt            *QueryOp
+	addr                [2]*QueryOp
+}
+
+// NewServiceDirectory creates a new ServiceDirectory for the given service type.
+// The callback may be nil if only Snapshot will be used.
+func NewServiceDirectory(serviceType string, f ServiceEventCallbackFunc) *ServiceDirectory {
+	return &ServiceDirectory{stype: serviceType, callback: f}
+}
+
+// StartServiceDirectory returns the equivalent of calling NewServiceDirectory and Start().
+func StartServiceDirectory(serviceType string, f ServiceEventCallbackFunc) (*ServiceDirectory, error) {
+	d := NewServiceDirectory(serviceType, f)
+	return d, d.Start()
+}
+
+// Type returns the service type associated with the directory.
+func (d *ServiceDirectory) Type() string {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.stype
+}
+
+// Domain returns the domain associated with the directory.
+func (d *ServiceDirectory) Domain() string {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.domain
+}
+
+// SetDomain sets the domain associated with the directory.
+func (d *ServiceDirectory) SetDomain(s string) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.started {
+		return ErrStarted
+	}
+	d.domain = s
+	return nil
+}
+
+// InterfaceIndex returns the interface index the directory is tied to.
+func (d *ServiceDirectory) InterfaceIndex() int {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.interfaceIndex
+}
+
+// SetInterfaceIndex sets the interface index the directory is tied to.
+func (d *ServiceDirectory) SetInterfaceIndex(i int) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.started {
+		return ErrStarted
+	}
+	d.interfaceIndex = i
+	return nil
+}
+
+// Active indicates whether the directory is active.
+func (d *ServiceDirectory) Active() bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.started
+}
+
+// Start begins browsing for services.
+func (d *ServiceDirectory) Start() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.started {
+		return ErrStarted
+	}
+	op := NewBrowseOp(d.stype, d.browseCallback)
+	if err := op.SetDomain(d.domain); err != nil {
+		return err
+	}
+	op.SetInterfaceIndex(d.interfaceIndex)
+	d.instances = make(map[string]*dirInstance)
+	d.browse = op
+	if err := op.Start(); err != nil {
+		d.browse = nil
+		return err
+	}
+	d.started = true
+	return nil
+}
+
+// Stop stops the directory and all of its underlying operations.
+// The set of services is cleared without removal events being delivered.
+func (d *ServiceDirectory) Stop() {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.stop()
+}
+
+func (d *ServiceDirectory) stop() {
+	if !d.started {
+		return
+	}
+	d.started = false
+	d.browse.Stop()
+	d.browse = nil
+	for _, inst := range d.instances {
+		inst.stop()
+	}
+	d.instances = nil
+}
+
+// Snapshot returns the services currently known to the directory, sorted by name.
+func (d *ServiceDirectory) Snapshot() []Service {
+	d.m.Lock()
+	defer d.m.Unlock()
+	var s []Service
+	for _, inst := range d.instances {
+		if inst.resolved {
+			s = append(s, inst.svc.copy())
+		}
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Name < s[j].Name })
+	return s
+}
+
+// emit invokes the callback. The directory's mutex must be held and is
+// released for the duration of the callback.
+func (d *ServiceDirectory) emit(err error, e ServiceEvent) {
+	if d.callback == nil {
+		return
+	}
+	d.m.Unlock()
+	defer d.m.Lock()
+	d.callback(d, err, e)
+}
+
+func instanceKey(name, stype, domain string) string {
+	return strings.ToLower(NormalizeName(name) + "\x00" + stype + "\x00" + domain)
+}
+
+func (inst *dirInstance) stop() {
+	if inst.resolve != nil {
+		inst.resolve.Stop()
+		inst.resolve = nil
+	}
+	for _, op := range []*QueryOp{inst.srv, inst.txt} {
+		if op != nil {
+			op.Stop()
+		}
+	}
+	inst.srv, inst.txt = nil, nil
+	inst.stopAddrs()
+}
+
+func (inst *dirInstance) stopAddrs() {
+	for i, op := range inst.addr {
+		if op != nil {
+			op.Stop()
+			inst.addr[i] = nil
+		}
+	}
+}
+
+func (d *ServiceDirectory) browseCallback(op *BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if op != d.browse {
+		return
+	}
+	if err != nil {
+		d.stop()
+		d.emit(err, ServiceEvent{})
+		return
+	}
+	key := instanceKey(name, serviceType, domain)
+	inst := d.instances[key]
+	if add {
+		if inst == nil {
+			inst = &dirInstance{name: name, stype: serviceType, domain: domain, ifaces: make(map[int]bool)}
+			d.instances[key] = inst
+		}
+		inst.ifaces[interfaceIndex] = true
+		if !inst.resolved && inst.resolve == nil {
+			d.startResolve(inst, interfaceIndex)
+		}
+		return
+	}
+	if inst == nil {
+		return
+	}
+	delete(inst.ifaces, interfaceIndex)
+	if len(inst.ifaces) > 0 {
+		d.interfaceLost(inst, interfaceIndex)
+		return
+	}
+	inst.stop()
+	delete(d.instances, key)
+	if inst.resolved {
+		d.emit(nil, ServiceEvent{Type: ServiceRemoved, Service: inst.svc.copy()})
+	}
+}
+
+func (d *ServiceDirectory) startResolve(inst *dirInstance, interfaceIndex int) {
+	inst.resolve = NewResolveOp(interfaceIndex, inst.name, inst.stype, inst.domain, nil)
+	inst.resolve.SetResultCallback(func(op *ResolveOp, err error, r ResolveResult) {
+		d.resolveCallback(inst, op, err, r)
+	})
+	if err := inst.resolve.Start(); err != nil {
+		inst.resolve = nil
+	}
+}
+
+// interfaceLost moves the ops of an instance that has been lost on one
+// interface but remains on others to one of the remaining interfaces.
+func (d *ServiceDirectory) interfaceLost(inst *dirInstance, interfaceIndex int) {
+	next := 0
+	for i := range inst.ifaces {
+		if next == 0 || i < next {
+			next = i
+		}
+	}
+	if inst.resolve != nil && inst.resolve.InterfaceIndex() == interfaceIndex {
+		inst.resolve.Stop()
+		d.startResolve(inst, next)
+	}
+	if !inst.resolved || inst.svc.InterfaceIndex != interfaceIndex {
+		return
+	}
+	inst.stop()
+	inst.svc.InterfaceIndex = next
+	inst.srv = d.startQuery(inst, inst.svc.FullName, rrTypeSRV, d.srvCallback)
+	inst.txt = d.startQuery(inst, inst.svc.FullName, rrTypeTXT, d.txtCallback)
+	d.startAddrs(inst)
+	var c ServiceChange
+	if len(inst.svc.Addrs) > 0 {
+		inst.svc.Addrs = nil
+		c |= ServiceAddrsChanged
+	}
+	d.update(inst, c)
+}
+
+func (d *ServiceDirectory) current(inst *dirInstance) bool {
+	return d.started && d.instances[instanceKey(inst.name, inst.stype, inst.domain)] == inst
+}
+
+func (d *ServiceDirectory) resolveCallback(inst *dirInstance, op *ResolveOp, err error, r ResolveResult) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if !d.current(inst) || inst.resolve != op {
+		return
+	}
+	op.Stop()
+	inst.resolve = nil
+	if err != nil {
+		// Try again when the instance is next seen.
+		return
+	}
+	inst.resolved = true
+	inst.svc = Service{
+		Name:           inst.name,
+		Type:           inst.stype,
+		Domain:         inst.domain,
+		FullName:       r.FullName,
+		InterfaceIndex: r.InterfaceIndex,
+		Host:           r.Host,
+		Port:           r.Port,
+		TXT:            r.TXT,
+	}
+	inst.srv = d.startQuery(inst, r.FullName, rrTypeSRV, d.srvCallback)
+	inst.txt = d.startQuery(inst, r.FullName, rrTypeTXT, d.txtCallback)
+	d.startAddrs(inst)
+	d.emit(nil, ServiceEvent{Type: ServiceAdded, Service: inst.svc.copy()})
+}
+
+type dirQueryCallback func(inst *dirInstance, op *QueryOp, add bool, interfaceIndex int, rdata []byte)
+
+func (d *ServiceDirectory) startQuery(inst *dirInstance, name string, rrtype uint16, f dirQueryCallback) *QueryOp {
+	op := NewQueryOp(inst.svc.InterfaceIndex, name, rrtype, rrClassIN, func(op *QueryOp, err error, add bool, interfaceIndex int, fullname string, rrtype, rrclass uint16, rdata []byte, ttl uint32) {
+		if err != nil {
+			// A failure here is either transient or will also be
+			// reported to the browse op.
+			return
+		}
+		f(inst, op, add, interfaceIndex, rdata)
+	})
+	if err := op.Start(); err != nil {
+		return nil
+	}
+	return op
+}
+
+func (d *ServiceDirectory) startAddrs(inst *dirInstance) {
+	inst.addr[0] = d.startQuery(inst, inst.svc.Host, rrTypeA, d.addrCallback)
+	inst.addr[1] = d.startQuery(inst, inst.svc.Host, rrTypeAAAA, d.addrCallback)
+}
+
+func (d *ServiceDirectory) update(inst *dirInstance, c ServiceChange) {
+	if c != 0 {
+		d.emit(nil, ServiceEvent{Type: ServiceUpdated, Service: inst.svc.copy(), Changes: c})
+	}
+}
+
+func (d *ServiceDirectory) srvCallback(inst *dirInstance, op *QueryOp, add bool, interfaceIndex int, rdata []byte) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if !d.current(inst) || inst.srv != op || !add {
+		return
+	}
+	port, target, err := decodeSRV(rdata)
+	if err != nil {
+		return
+	}
+	var c ServiceChange
+	if port != inst.svc.Port {
+		inst.svc.Port = port
+		c |= ServicePortChanged
+	}
+	if !strings.EqualFold(target, inst.svc.Host) {
+		inst.svc.Host = target
+		inst.stopAddrs()
+		c |= ServiceHostChanged
+		if len(inst.svc.Addrs) > 0 {
+			inst.svc.Addrs = nil
+			c |= ServiceAddrsChanged
+		}
+		d.startAddrs(inst)
+	}
+	d.update(inst, c)
+}
+
+func (d *ServiceDirectory) txtCallback(inst *dirInstance, op *QueryOp, add bool, interfaceIndex int, rdata []byte) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if !d.current(inst) || inst.txt != op || !add {
+		return
+	}
+	txt, _ := UnmarshalTXTRecord(rdata)
+	if !txtEqual(txt, inst.svc.TXT) {
+		inst.svc.TXT = txt
+		d.update(inst, ServiceTXTChanged)
+	}
+}
+
+func (d *ServiceDirectory) addrCallback(inst *dirInstance, op *QueryOp, add bool, interfaceIndex int, rdata []byte) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if !d.current(inst) || (inst.addr[0] != op && inst.addr[1] != op) {
+		return
+	}
+	if len(rdata) != net.IPv4len && len(rdata) != net.IPv6len {
+		return
+	}
+	a := ipAddr(rdata, interfaceIndex)
+	i := 0
+	for ; i < len(inst.svc.Addrs); i++ {
+		if inst.svc.Addrs[i].IP.Equal(a.IP) {
+			break
+		}
+	}
+	switch {
+	case add && i == len(inst.svc.Addrs):
+		inst.svc.Addrs = append(inst.svc.Addrs, a)
+	case !add && i < len(inst.svc.Addrs):
+		inst.svc.Addrs = append(inst.svc.Addrs[:i:i], inst.svc.Addrs[i+1:]...)
+	default:
+		return
+	}
+	d.update(inst, ServiceAddrsChanged)
+}
+
+// ipAddr returns the address contained in A or AAAA rdata, setting the zone
+// of link-local IPv6 addresses to the name of the interface.
+func ipAddr(rdata []byte, interfaceIndex int) net.IPAddr {
+	a := net.IPAddr{IP: append(net.IP(nil), rdata...)}
+	if len(rdata) == net.IPv6len && a.IP.IsLinkLocalUnicast() {
+		if ifi, err := net.InterfaceByIndex(interfaceIndex); err == nil {
+			a.Zone = ifi.Name
+		}
+	}
+	return a
+}
+
+func txtEqual(a, b TXTRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Bool() != b[i].Bool() || string(a[i].Value) != string(b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,237 @@
+package dnssd
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults used by Discover when the corresponding option isn't set.
+const (
+	DefaultDiscoverQuietPeriod    = time.Second
+	DefaultDiscoverResolveTimeout = 5 * time.Second
+	DefaultDiscoverMaxResolves    = 8
+)
+
+// DiscoverOptions configures Discover. A nil *DiscoverOptions or zero valued
+// fields use the defaults.
+type DiscoverOptions struct {
+	// InterfaceIndex is the interface to browse on.
+	InterfaceIndex int
+	// QuietPeriod is how long browsing continues after the last new
+	// instance is seen.
+	QuietPeriod time.Duration
+	// ResolveTimeout limits how long resolving each instance and looking
+	// up its addresses may take, and how long resolves still pending when
+	// the quiet period ends may continue.
+	ResolveTimeout time.Duration
+	// MaxResolves limits how many instances are resolved concurrently.
+	MaxResolves int
+}
+
+// Discover browses for services of the given type until ctx is done or no new
+// instances have been seen for the quiet period, and returns the instances
+// found, resolved and with their addresses, sorted by name.
+//
+// Discover returns by the time ctx is done. Resolves still pending then, or
+// ResolveTimeout after the quiet period ends, are abandoned and their
+// instances are returned unresolved, with only Name, Type, Domain and
+// InterfaceIndex set. Instances that fail to resolve or that are removed
+// while browsing are omitted. An instance seen on several interfaces is
+// returned once, resolved on the first interface it was seen on.
+//
+// An error is only returned if browsing fails. All ops are stopped before it
+// returns. Discover may be called from within a callback.
+func Discover(ctx context.Context, serviceType, domain string, opts *DiscoverOptions) ([]Service, error) {
+	var o DiscoverOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.QuietPeriod <= 0 {
+		o.QuietPeriod = DefaultDiscoverQuietPeriod
+	}
+	if o.ResolveTimeout <= 0 {
+		o.ResolveTimeout = DefaultDiscoverResolveTimeout
+	}
+	if o.MaxResolves <= 0 {
+		o.MaxResolves = DefaultDiscoverMaxResolves
+	}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d := &discovery{
+		ctx:       rctx,
+		opts:      o,
+		sem:       make(chan struct{}, o.MaxResolves),
+		found:     make(chan struct{}, 1),
+		browseErr: make(chan error, 1),
+		instances: make(map[string]*discovered),
+	}
+	op := NewBrowseOp(serviceType, d.browseCallback)
+	op.direct = true
+	op.SetInterfaceIndex(o.InterfaceIndex)
+	if err := op.SetDomain(domain); err != nil {
+		return nil, err
+	}
+	if err := op.Start(); err != nil {
+		return nil, err
+	}
+	err := d.wait(ctx)
+	op.Stop()
+	d.m.Lock()
+	d.done = true
+	d.m.Unlock()
+	if err != nil {
+		cancel()
+	} else {
+		// Give resolves still pending after the quiet period a little
+		// longer, which is cut short if ctx is done first.
+		t := time.AfterFunc(o.ResolveTimeout, cancel)
+		defer t.Stop()
+	}
+	d.wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	var services []Service
+	d.m.Lock()
+	for _, inst := range d.instances {
+		if inst.resolved || inst.abandoned {
+			services = append(services, inst.svc)
+		}
+	}
+	d.m.Unlock()
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+type discovery struct {
+	ctx       context.Context // bounds resolves
+	opts      DiscoverOptions
+	sem       chan struct{}
+	found     chan struct{}
+	browseErr chan error
+	wg        sync.WaitGroup
+	m         sync.Mutex
+	done      bool
+	instances map[string]*discovered
+}
+
+type discovered struct {
+	interfaces map[int]bool
+	resolved   bool
+	// abandoned is set if resolving was cut short by d.ctx.
+	abandoned bool
+	svc       Service
+}
+
+// wait returns when the quiet period passes, ctx is done or browsing fails.
+func (d *discovery) wait(ctx context.Context) error {
+	timer := time.NewTimer(d.opts.QuietPeriod)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+			return nil
+		case err := <-d.browseErr:
+			return err
+		case <-d.found:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(d.opts.QuietPeriod)
+		}
+	}
+}
+
+func (d *discovery) browseCallback(op *BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+	if err != nil {
+		select {
+		case d.browseErr <- err:
+		default:
+		}
+		return
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.done {
+		return
+	}
+	key := instanceKey(name, serviceType, domain)
+	inst := d.instances[key]
+	if !add {
+		if inst != nil {
+			delete(inst.interfaces, interfaceIndex)
+			if len(inst.interfaces) == 0 {
+				delete(d.instances, key)
+			}
+		}
+		return
+	}
+	if inst != nil {
+		inst.interfaces[interfaceIndex] = true
+		return
+	}
+	inst = &discovered{
+		interfaces: map[int]bool{interfaceIndex: true},
+		svc:        Service{Name: name, Type: serviceType, Domain: domain, InterfaceIndex: interfaceIndex},
+	}
+	d.instances[key] = inst
+	d.wg.Add(1)
+	go d.resolve(inst, interfaceIndex, name, serviceType, domain)
+	select {
+	case d.found <- struct{}{}:
+	default:
+	}
+}
+
+// resolve resolves an instance and looks up the addresses of its host.
+func (d *discovery) resolve(inst *discovered, interfaceIndex int, name, serviceType, domain string) {
+	defer d.wg.Done()
+	select {
+	case d.sem <- struct{}{}:
+		defer func() { <-d.sem }()
+	case <-d.ctx.Done():
+		d.abandon(inst)
+		return
+	}
+	ctx, cancel := context.WithTimeout(d.ctx, d.opts.ResolveTimeout)
+	defer cancel()
+	r, err := resolveInstance(ctx, interfaceIndex, name, serviceType, domain)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			d.abandon(inst)
+		}
+		return
+	}
+	svc := Service{
+		Name:           name,
+		Type:           serviceType,
+		Domain:         domain,
+		FullName:       r.FullName,
+		InterfaceIndex: r.InterfaceIndex,
+		Host:           r.Host,
+		Port:           r.Port,
+		TXT:            r.TXT,
+	}
+	resolver := &Resolver{InterfaceIndex: r.InterfaceIndex}
+	results, _ := resolver.lookupAddrs(ctx, r.Host)
+	for _, qr := range results {
+		svc.Addrs = append(svc.Addrs, ipAddr(qr.rdata, qr.interfaceIndex))
+	}
+	d.m.Lock()
+	inst.svc, inst.resolved = svc, true
+	d.m.Unlock()
+}
+
+// abandon marks an instance whose resolve was cut short by d.ctx.
+func (d *discovery) abandon(inst *discovered) {
+	d.m.Lock()
+	inst.abandoned = true
+	d.m.Unlock()
+}
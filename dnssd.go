@@ -24,17 +24,22 @@
 //
 // The DNS Service Discovery API is wrapped as follows:
 //
-//  DNSServiceRegister()    -> RegisterOp
-//  DNSServiceBrowse()      -> BrowseOp
-//  DNSServiceResolve()     -> ResolveOp
-//  DNSServiceQueryRecord() -> QueryOp
+//  DNSServiceRegister()         -> RegisterOp
+//  DNSServiceBrowse()           -> BrowseOp
+//  DNSServiceResolve()          -> ResolveOp
+//  DNSServiceQueryRecord()      -> QueryOp
+//  DNSServiceEnumerateDomains() -> DomainEnumOp
+//  DNSServiceReconfirmRecord()  -> ReconfirmRecord
 //
 // All operations require a callback be set. RegisterOp, BrowseOp and ResolveOp
 // require a service type be set. QueryOp requires name, class and type be set.
 // If an InterfaceIndex is not set the default value of InterfaceIndexAny is
 // used which applies the operation to all network interfaces. For operations
 // that take a domain, if no domain is set or the domain is set to an empty
-// string the operation applies to all applicable DNS-SD domains.
+// string the operation applies to all applicable DNS-SD domains. Start checks
+// an operation's parameters with Validate unless SetSkipValidation is used.
+// Service names are normalized to NFC and internationalized unicast domains
+// are converted to their IDNA ASCII form.
 //
 // If a service is registered with an empty string as it's name, the local
 // computer name (or hostname) will be substitued. If no host is specified a
@@ -43,11 +48,14 @@
 //
 // Callbacks are executed in serial. If an error is supplied to a callback
 // the operation will no longer be active and other arguments must be ignored.
+// The exception is a negative answer to a QueryOp with ReturnIntermediates set.
 //
 package dnssd
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -58,9 +66,18 @@ const InterfaceIndexAny = 0
 const InterfaceIndexLocalOnly = int(^uint(0) >> 1)
 
 const (
-	_FlagsAdd             uint32 = 0x2
-	_FlagsNoAutoRename           = 0x8
-	_FlagsShareConnection        = 0x4000
+	_FlagsMoreComing          uint32 = 0x1
+	_FlagsAdd                        = 0x2
+	_FlagsDefault                    = 0x4
+	_FlagsNoAutoRename               = 0x8
+	_FlagsBrowseDomains              = 0x40
+	_FlagsRegistrationDomains        = 0x80
+	_FlagsLongLivedQuery             = 0x100
+	_FlagsForceMulticast             = 0x400
+	_FlagsReturnIntermediates        = 0x1000
+	_FlagsShareConnection            = 0x4000
+	_FlagsSuppressUnusable           = 0x8000
+	_FlagsTimeout                    = 0x10000
 )
 
 type baseOp struct {
@@ -69,12 +86,19 @@ type baseOp struct {
 	started        bool
 	interfaceIndex int
 	flags          uint32
+	skipValidation bool
+	// direct is set by ops used internally to have their callbacks called
+	// on the poll goroutine, so that they can be waited for from within a
+	// callback. Such callbacks must not block or call into the package.
+	direct bool
 }
 
 var callbackQueueState struct {
 	sync.Mutex
 	c chan bool
 	f []func()
+	// n is the number of callbacks queued or being delivered.
+	n int64
 }
 
 func queueCallback(f func()) {
@@ -85,6 +109,10 @@ func queueCallback(f func()) {
 		go callbackQueueLoop()
 	}
 	callbackQueueState.f = append(callbackQueueState.f, f)
+	n := atomic.AddInt64(&callbackQueueState.n, 1)
+	if o := observer(); o != nil {
+		o.CallbackQueueDepth(int(n))
+	}
 	select {
 	case callbackQueueState.c <- true:
 	default:
@@ -100,10 +128,24 @@ func callbackQueueLoop() {
 		callbackQueueState.Unlock()
 		for i := range f {
 			f[i]()
+			n := atomic.AddInt64(&callbackQueueState.n, -1)
+			if o := observer(); o != nil {
+				o.CallbackDelivered()
+				o.CallbackQueueDepth(int(n))
+			}
 		}
 	}
 }
 
+// deliver calls f directly if the op is direct and otherwise queues it.
+func (o *baseOp) deliver(f func()) {
+	if o.direct {
+		f()
+	} else {
+		queueCallback(f)
+	}
+}
+
 func (o *baseOp) setFlag(flag uint32, enabled bool) {
 	set := o.flags&flag != 0
 	if set != enabled {
@@ -143,6 +185,14 @@ func (o *baseOp) interfaceIndexC() uint32 {
 	return uint32(o.interfaceIndex)
 }
 
+// interfaceIndexGo converts an interface index from the C API.
+func interfaceIndexGo(i uint32) int {
+	if i == ^uint32(0) {
+		return InterfaceIndexLocalOnly
+	}
+	return int(i)
+}
+
 func (o *baseOp) init(sharedref uintptr) (ref uintptr, err error) {
 	panic("unreachable")
 }
@@ -167,3 +217,19 @@ func deallocateRef(ref *uintptr) {
 		*ref = 0
 	}
 }
+
+// validSubtype reports whether s can be used as a service subtype label in
+// both the "_sub" and comma separated forms of a service type.
+func validSubtype(s string) bool {
+	return len(s) > 0 && len(s) <= 63 && !strings.ContainsAny(s, ".,\\")
+}
+
+// parentServiceType strips the subtype, if any, from a service type in the
+// form "_printer._sub._http._tcp".
+func parentServiceType(s string) string {
+	const sep = "._sub."
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[i+len(sep):]
+	}
+	return s
+}
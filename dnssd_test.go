@@ -1,8 +1,18 @@
 package dnssd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -82,37 +92,840 @@ func TestBrowseStartStop(t *testing.T) {
 }
 
 func TestResolveStartStop(t *testing.T) {
-	f := func(op *ResolveOp, e error, host string, port int, txt map[string]string) {
+	f := func(op *ResolveOp, e error, host string, port int, txt TXTRecord) {
 	}
 	StartStopHelper(t, NewResolveOp(0, "go", "_go-dnssd._tcp", "local", f))
 }
 
 func TestDecodeTxtBadLength(t *testing.T) {
-	b := []byte{255, 'b', '=', 'b'}
-	m := decodeTxt(b)
-	if v, p := m["b"]; p != false {
+	b := []byte{3, 'a', '=', 'a', 255, 'b', '=', 'b'}
+	r, err := UnmarshalTXTRecord(b)
+	if err != ErrTXTMalformed {
+		t.Fatalf("Expected ErrTXTMalformed, got: %v", err)
+	}
+	if v, p := r.Get("b"); p != false {
 		t.Fatalf(`Expected pair "b" to be missing, instead it's present with value %v`, v)
 	}
+	if v, _ := r.Get("a"); string(v) != "a" {
+		t.Fatalf(`Expected pair "a" preceding the bad length to be decoded, got %v`, v)
+	}
 }
 
 func TestDecodeTxtKeyNoValue(t *testing.T) {
-	b := []byte{1, 'a', 2, 'b', '=', 1, '=', 2, '=', 'a'}
-	m := decodeTxt(b)
-	keys := []string{"a", "b", "=", "=a"}
-	for _, k := range keys {
-		if v, p := m[k]; v != "" {
-			t.Fatalf(`Expected "%s" to return empty string, got %v instead (present: %v)`, k, v, p)
+	b := []byte{1, 'a', 2, 'b', '=', 1, '=', 2, '=', 'a', 0}
+	r, err := UnmarshalTXTRecord(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(r) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(r), r)
+	}
+	if v, p := r.Get("a"); !p || v != nil || !r[0].Bool() {
+		t.Fatalf(`Expected "a" to be present without a value, got %v (present: %v)`, v, p)
+	}
+	if v, p := r.Get("b"); !p || v == nil || len(v) != 0 || r[1].Bool() {
+		t.Fatalf(`Expected "b" to be present with an empty value, got %v (present: %v)`, v, p)
+	}
+	for _, k := range []string{"=", "=a", ""} {
+		if v, p := r.Get(k); p {
+			t.Fatalf(`Expected %q to be missing, instead it's present with value %v`, k, v)
 		}
 	}
 }
 
 func TestDecodeTxtKeyValue(t *testing.T) {
 	b := []byte{3, 'a', '=', 'a', 3, 'b', '=', 'b', 5, 'a', 'b', '=', 'a', 'b'}
-	m := decodeTxt(b)
-	for _, kv := range []string{"a", "b", "ab"} {
-		if v, p := m[kv]; v != kv {
+	r, err := UnmarshalTXTRecord(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, kv := range []string{"a", "b", "ab"} {
+		if v, p := r.Get(kv); string(v) != kv {
 			t.Fatalf(`Expected "%s" to return "%s", got %v instead (present: %v)`, kv, kv, v, p)
 		}
+		if r[i].Key != kv {
+			t.Fatalf(`Expected entry %d to have key "%s", got "%s"`, i, kv, r[i].Key)
+		}
+	}
+}
+
+func TestTXTRecordFirstKeyWins(t *testing.T) {
+	b := []byte{3, 'K', '=', '1', 3, 'k', '=', '2', 3, 'k', '=', 0xff}
+	r, err := UnmarshalTXTRecord(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(r) != 3 {
+		t.Fatalf("Expected duplicate keys to be retained, got %d entries", len(r))
+	}
+	if v, _ := r.Get("k"); string(v) != "1" {
+		t.Fatalf(`Expected first occurrence of "k" to win, got %q`, v)
+	}
+	if m := r.Map(); len(m) != 1 || m["K"] != "1" {
+		t.Fatalf(`Expected map containing only K=1, got %v`, m)
+	}
+	if err := r.Set("k", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r[0].Key != "k" || !r[0].Bool() {
+		t.Fatalf("Expected Set to replace the first entry, got %v", r)
+	}
+	r.Delete("K")
+	if len(r) != 0 {
+		t.Fatalf("Expected Delete to remove all occurrences, got %v", r)
+	}
+}
+
+func TestTXTRecordMarshal(t *testing.T) {
+	r := TXTRecord{{"txtvers", []byte("1")}, {"bool", nil}, {"empty", []byte{}}, {"bin", []byte{0, '=', 0xff}}}
+	b, err := MarshalTXTRecord(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "\x09txtvers=1\x04bool\x06empty=\x07bin=\x00=\xff"
+	if string(b) != want {
+		t.Fatalf("Expected %q, got %q", want, b)
+	}
+	if b, err := MarshalTXTRecord(nil); err != nil || string(b) != "\x00" {
+		t.Fatalf("Expected empty record to marshal to a single empty string, got %q (%v)", b, err)
+	}
+	for _, e := range []TXTEntry{{"", nil}, {"a=b", nil}} {
+		if _, err := MarshalTXTRecord(TXTRecord{e}); err != ErrTXTKey {
+			t.Fatalf("Expected ErrTXTKey for key %q, got: %v", e.Key, err)
+		}
+	}
+	if _, err := MarshalTXTRecord(TXTRecord{{"a", make([]byte, 254)}}); err != ErrTXTStringLen {
+		t.Fatalf("Expected ErrTXTStringLen, got: %v", err)
+	}
+}
+
+func FuzzUnmarshalTXTRecord(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{3, 'a', '=', 'a', 1, 'b', 2, 'c', '='})
+	f.Add([]byte{255, 'b', '=', 'b'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r, err := UnmarshalTXTRecord(b)
+		if err != nil && err != ErrTXTMalformed {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		m, err := MarshalTXTRecord(r)
+		if err != nil {
+			t.Fatalf("Failed to marshal decoded record %v: %v", r, err)
+		}
+		r2, err := UnmarshalTXTRecord(m)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %q: %v", m, err)
+		}
+		if len(r) != len(r2) {
+			t.Fatalf("Round trip changed entry count from %d to %d", len(r), len(r2))
+		}
+		for i := range r {
+			if r[i].Key != r2[i].Key || r[i].Bool() != r2[i].Bool() || string(r[i].Value) != string(r2[i].Value) {
+				t.Fatalf("Round trip changed entry %d from %v to %v", i, r[i], r2[i])
+			}
+		}
+	})
+}
+
+type txtTestStruct struct {
+	Version  int    `txt:"txtvers"`
+	Path     string `txt:"path,omitempty"`
+	Secure   bool   `txt:"secure"`
+	Note     string `txt:"note"`
+	Key      []byte `txt:"key,omitempty"`
+	Port     uint16 `txt:"port,omitempty"`
+	Addr     net.IP `txt:"addr,omitempty"`
+	Skipped  string `txt:"-"`
+	internal string
+}
+
+func TestMarshalTXT(t *testing.T) {
+	v := txtTestStruct{Version: 1, Secure: true, Key: []byte{0, 1}, Addr: net.IPv4(192, 0, 2, 1), Skipped: "x", internal: "x"}
+	r, err := MarshalTXT(v)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var keys []string
+	for _, e := range r {
+		keys = append(keys, e.Key)
+	}
+	if s := strings.Join(keys, ","); s != "txtvers,secure,note,key,addr" {
+		t.Fatalf("Unexpected keys: %s", s)
+	}
+	if !r[1].Bool() || r[2].Bool() || string(r[4].Value) != "192.0.2.1" {
+		t.Fatalf("Unexpected record: %v", r)
+	}
+	var u txtTestStruct
+	if err := UnmarshalTXT(r, &u); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if u.Version != 1 || !u.Secure || string(u.Key) != string(v.Key) || !u.Addr.Equal(v.Addr) || u.Skipped != "" {
+		t.Fatalf("Round trip mismatch: %+v", u)
+	}
+	if err := UnmarshalTXT(TXTRecord{{"PORT", []byte("70000")}}, &u); !errors.Is(err, strconv.ErrRange) {
+		t.Fatalf("Expected range error, got: %v", err)
+	}
+	if u.Secure {
+		t.Fatal("Expected absent bool key to unmarshal as false")
+	}
+	v.Note = strings.Repeat("a", 251)
+	if _, err := MarshalTXT(&v); !errors.Is(err, ErrTXTStringLen) {
+		t.Fatalf("Expected ErrTXTStringLen, got: %v", err)
+	}
+	if err := UnmarshalTXT(r, v); err != ErrTXTTarget {
+		t.Fatalf("Expected ErrTXTTarget, got: %v", err)
+	}
+}
+
+func TestDecodeSRV(t *testing.T) {
+	b := []byte{0, 1, 0, 2, 0x1f, 0x90, 4, 'h', '.', 's', 't', 5, 'l', 'o', 'c', 'a', 'l', 0}
+	port, target, err := decodeSRV(b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if port != 8080 || target != `h\.st.local.` {
+		t.Fatalf(`Expected port 8080 and target "h\.st.local.", got %d and %q`, port, target)
+	}
+	for _, b := range [][]byte{b[:6], b[:len(b)-1], {0, 0, 0, 0, 0, 0, 64}} {
+		if _, _, err := decodeSRV(b); err == nil {
+			t.Fatalf("Expected error decoding %v", b)
+		}
+	}
+}
+
+func TestServiceDirectoryInterfaceLost(t *testing.T) {
+	ifaces, _ := net.Interfaces()
+	lo := 0
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			lo = ifi.Index
+		}
+	}
+	if lo == 0 {
+		t.Skip("No loopback interface")
+	}
+	events := make(chan ServiceEvent, 8)
+	d := NewServiceDirectory("_go-dnssd-test._tcp", func(d *ServiceDirectory, err error, e ServiceEvent) {
+		events <- e
+	})
+	if err := d.Start(); err != nil {
+		t.Fatalf("Couldn't start directory: %v", err)
+	}
+	defer d.Stop()
+	// Report an instance on two interfaces, resolved on the first, as the
+	// browse and resolve ops would.
+	name, stype, domain := "go-dnssd-iface", "_go-dnssd-test._tcp.", "local."
+	d.browseCallback(d.browse, nil, true, lo, name, stype, domain)
+	d.browseCallback(d.browse, nil, true, InterfaceIndexLocalOnly, name, stype, domain)
+	d.m.Lock()
+	inst := d.instances[instanceKey(name, stype, domain)]
+	resolve := inst.resolve
+	d.m.Unlock()
+	if resolve == nil || resolve.InterfaceIndex() != lo {
+		t.Fatal("Expected instance to be resolved on the first interface")
+	}
+	d.resolveCallback(inst, resolve, nil, ResolveResult{
+		InterfaceIndex: lo,
+		FullName:       name + "." + stype + domain,
+		Host:           "go-dnssd-iface.local.",
+		Port:           9,
+	})
+	if e := <-events; e.Type != ServiceAdded {
+		t.Fatalf("Expected service to be added, got %v", e.Type)
+	}
+	d.m.Lock()
+	inst.svc.Addrs = []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}
+	d.m.Unlock()
+	d.browseCallback(d.browse, nil, false, lo, name, stype, domain)
+	select {
+	case e := <-events:
+		if e.Type != ServiceUpdated || e.Changes != ServiceAddrsChanged || e.Service.InterfaceIndex != InterfaceIndexLocalOnly {
+			t.Fatalf("Expected addresses to be cleared and the interface to change, got %+v", e)
+		}
+	default:
+		t.Fatal("Expected an update when the resolved interface was lost")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	for _, op := range []*QueryOp{inst.srv, inst.txt, inst.addr[0], inst.addr[1]} {
+		if op == nil || op.InterfaceIndex() != InterfaceIndexLocalOnly {
+			t.Fatal("Expected queries to be restarted on the remaining interface")
+		}
+	}
+}
+
+func TestRecordCacheExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewRecordCache()
+	c.SetClock(func() time.Time { return now })
+	e := &cacheEntry{key: newRecordKey("Host.local", 1, 1)}
+	c.entries[e.key] = e
+	a, b := []byte{192, 0, 2, 1}, []byte{192, 0, 2, 2}
+	if _, changed := e.apply(true, 1, "host.local.", a, 10, now); !changed {
+		t.Fatal("Expected adding a record to change the RRset")
+	}
+	if _, changed := e.apply(true, 1, "host.local.", b, 20, now); !changed {
+		t.Fatal("Expected adding a record to change the RRset")
+	}
+	if _, changed := e.apply(true, 1, "host.local.", a, 10, now); changed {
+		t.Fatal("Expected refreshing a record not to change the RRset")
+	}
+	if r := c.Get("host.local.", 1, 1); len(r) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(r))
+	}
+	now = now.Add(15 * time.Second)
+	if r := c.Get("HOST.local", 1, 1); len(r) != 1 || string(r[0].Data) != string(b) {
+		t.Fatalf("Expected only the record with the longer TTL to remain, got %v", r)
+	}
+	if r, changed := e.apply(false, 1, "host.local.", b, 0, now); !changed || string(r.Data) != string(b) {
+		t.Fatalf("Expected removing a record to change the RRset, got %v (%v)", r, changed)
+	}
+	if r := c.Get("host.local", 1, 1); len(r) != 0 {
+		t.Fatalf("Expected no records, got %v", r)
+	}
+	if len(c.entries) != 0 {
+		t.Fatal("Expected empty entry to be discarded")
+	}
+}
+
+func TestRecordCacheSweep(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewRecordCache()
+	c.SetClock(func() time.Time { return now })
+	stale := &cacheEntry{key: newRecordKey("stale.local", 1, 1)}
+	fresh := &cacheEntry{key: newRecordKey("fresh.local", 1, 1)}
+	c.entries[stale.key], c.entries[fresh.key] = stale, fresh
+	stale.apply(true, 1, "stale.local.", []byte{192, 0, 2, 1}, 10, now)
+	fresh.apply(true, 1, "fresh.local.", []byte{192, 0, 2, 2}, 60, now)
+	now = now.Add(30 * time.Second)
+	if r := c.Get("fresh.local", 1, 1); len(r) != 1 {
+		t.Fatalf("Expected 1 record, got %v", r)
+	}
+	if _, ok := c.entries[stale.key]; ok || len(c.entries) != 1 {
+		t.Fatalf("Expected the unrelated stale entry to be evicted, got %d entries", len(c.entries))
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	for in, out := range map[string]string{
+		"192.0.2.1":       "1.2.0.192.in-addr.arpa.",
+		"fe80::1%en0":     "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.",
+		"2001:db8::abcd":  "d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"::ffff:10.0.0.1": "1.0.0.10.in-addr.arpa.",
+	} {
+		ip, _ := parseIPZone(in)
+		if s := reverseName(ip); s != out {
+			t.Fatalf("Expected reverseName(%s) to return %s, got %s", in, out, s)
+		}
+	}
+}
+
+// fakeLookup returns a lookup function answering each address type after
+// the given delay, or blocking until ctx is done if there's no answer.
+func fakeLookup(answers map[uint16]queryResult, delays map[uint16]time.Duration, types chan<- uint16) func(context.Context, uint16) ([]queryResult, error) {
+	return func(ctx context.Context, rrtype uint16) ([]queryResult, error) {
+		if types != nil {
+			types <- rrtype
+		}
+		qr, ok := answers[rrtype]
+		if !ok {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		select {
+		case <-time.After(delays[rrtype]):
+			return []queryResult{qr}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// recordingDialer returns a ServiceDialer that records the addresses it
+// attempts to connect to, and a function returning them.
+func recordingDialer() (*ServiceDialer, func() []string) {
+	var (
+		m        sync.Mutex
+		attempts []string
+	)
+	d := &ServiceDialer{AttemptDelay: 10 * time.Millisecond}
+	d.Dialer.Control = func(network, address string, c syscall.RawConn) error {
+		m.Lock()
+		attempts = append(attempts, address)
+		m.Unlock()
+		return nil
+	}
+	return d, func() []string {
+		m.Lock()
+		defer m.Unlock()
+		return append([]string(nil), attempts...)
+	}
+}
+
+func listenLoopback(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln
+}
+
+func TestDialParallel(t *testing.T) {
+	ln := listenLoopback(t)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	answers := map[uint16]queryResult{
+		rrTypeA:    {rrtype: rrTypeA, rdata: []byte{127, 0, 0, 1}},
+		rrTypeAAAA: {rrtype: rrTypeAAAA, rdata: net.IPv6loopback},
+	}
+	d, attempts := recordingDialer()
+	c, results, err := d.dialParallel(context.Background(), "tcp", fakeLookup(answers, nil, nil), port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.Close()
+	if a := c.RemoteAddr().(*net.TCPAddr); !a.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("Expected connection to 127.0.0.1, got %v", a)
+	}
+	if a := attempts(); len(a) == 0 || !strings.HasPrefix(a[0], "[::1]") {
+		t.Fatalf("Expected IPv6 address to be attempted first, got %v", a)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected both addresses to be returned, got %v", results)
+	}
+	types := make(chan uint16, 2)
+	c, _, err = d.dialParallel(context.Background(), "tcp4", fakeLookup(answers, nil, types), port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.Close()
+	if len(types) != 1 || <-types != rrTypeA {
+		t.Fatal("Expected tcp4 to only look up IPv4 addresses")
+	}
+}
+
+func TestDialParallelDelayedAAAA(t *testing.T) {
+	ln := listenLoopback(t)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+	answers := map[uint16]queryResult{rrTypeA: {rrtype: rrTypeA, rdata: []byte{127, 0, 0, 1}}}
+	d, attempts := recordingDialer()
+	d.ResolutionDelay = 10 * time.Millisecond
+	// The AAAA query never answers, as if waiting for it to time out.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	c, _, err := d.dialParallel(ctx, "tcp", fakeLookup(answers, nil, nil), port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected dialing not to wait for the AAAA query, took %v", elapsed)
+	}
+	// An AAAA answer within the resolution delay is attempted first.
+	answers = map[uint16]queryResult{
+		rrTypeA:    answers[rrTypeA],
+		rrTypeAAAA: {rrtype: rrTypeAAAA, rdata: net.IPv6loopback},
+	}
+	d, attempts = recordingDialer()
+	d.ResolutionDelay = time.Second
+	delays := map[uint16]time.Duration{rrTypeAAAA: 20 * time.Millisecond}
+	c, _, err = d.dialParallel(ctx, "tcp", fakeLookup(answers, delays, nil), port)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c.Close()
+	if a := attempts(); len(a) == 0 || !strings.HasPrefix(a[0], "[::1]") {
+		t.Fatalf("Expected IPv6 address to be attempted first, got %v", a)
+	}
+}
+
+func TestParseServiceHost(t *testing.T) {
+	for in, out := range map[string][3]string{
+		"My%20Device._http._tcp.local":    {"My Device", "_http._tcp", "local"},
+		"My Device._http._tcp.local.":     {"My Device", "_http._tcp", "local"},
+		"v1.2 Printer._ipp._tcp":          {"v1.2 Printer", "_ipp._tcp", ""},
+		"Host._tcpish._http._udp.example": {"Host._tcpish", "_http._udp", "example"},
+	} {
+		instance, serviceType, domain, ok := parseServiceHost(in)
+		if !ok || instance != out[0] || serviceType != out[1] || domain != out[2] {
+			t.Fatalf("parseServiceHost(%q) returned %q, %q, %q, %v", in, instance, serviceType, domain, ok)
+		}
+	}
+	for _, in := range []string{"example.com", "_http._tcp.local", "127.0.0.1"} {
+		if _, _, _, ok := parseServiceHost(in); ok {
+			t.Fatalf("Expected parseServiceHost(%q) to fail", in)
+		}
+	}
+	u := ServiceURL("http", "My Device", "_http._tcp", "local.", "/status")
+	if instance, _, _, ok := parseServiceHost(u.Hostname()); !ok || instance != "My Device" {
+		t.Fatalf("Expected ServiceURL host %q to round trip", u.Host)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestServiceTransportRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		txtPath, path, rawPath string
+		wantPath, wantRawPath  string
+	}{
+		{"", "/status", "", "/status", ""},
+		{"/", "/status", "", "/status", ""},
+		{"/", "", "", "", ""},
+		{"/api", "/status", "", "/api/status", ""},
+		{"/api", "", "", "/api", ""},
+		{"/api", "/", "", "/api/", ""},
+		{"api/", "/status", "", "/api/status", ""},
+		{"api/", "", "", "/api/", ""},
+		{"/api", "/a/b", "/a%2Fb", "/api/a/b", "/api/a%2Fb"},
+		{"/my api", "/a/b", "/a%2Fb", "/my api/a/b", "/my%20api/a%2Fb"},
+	} {
+		var txt TXTRecord
+		if c.txtPath != "" {
+			txt.Set("path", []byte(c.txtPath))
+		}
+		var got *http.Request
+		tr := &ServiceTransport{
+			Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				got = r
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+			}),
+			resolveFunc: func(req *http.Request, instance, serviceType, domain string) (ResolveResult, error) {
+				if instance != "My Device" || serviceType != "_http._tcp" || domain != "local" {
+					t.Fatalf("Unexpected resolve of %q, %q, %q", instance, serviceType, domain)
+				}
+				return ResolveResult{Host: "host.local.", Port: 8080, TXT: txt}, nil
+			},
+		}
+		u := ServiceURL("http", "My Device", "_http._tcp", "local.", c.path)
+		u.RawPath = c.rawPath
+		if _, err := tr.RoundTrip(&http.Request{Method: "GET", URL: u, Header: http.Header{}}); err != nil {
+			t.Fatalf("RoundTrip failed: %v", err)
+		}
+		if got.URL.Host != "host.local:8080" || got.Host != "host.local:8080" {
+			t.Fatalf("Expected request to be sent to host.local:8080, got %q, %q", got.URL.Host, got.Host)
+		}
+		if got.URL.Path != c.wantPath || got.URL.RawPath != c.wantRawPath {
+			t.Fatalf("path=%q with request path %q: got %q (raw %q), want %q (raw %q)", c.txtPath, c.path, got.URL.Path, got.URL.RawPath, c.wantPath, c.wantRawPath)
+		}
+	}
+}
+
+func TestListenInterfaceIndex(t *testing.T) {
+	for ip, want := range map[string]int{
+		"0.0.0.0":   InterfaceIndexAny,
+		"::":        InterfaceIndexAny,
+		"127.0.0.1": InterfaceIndexLocalOnly,
+		"::1":       InterfaceIndexLocalOnly,
+		"192.0.2.1": InterfaceIndexAny,
+	} {
+		if i := listenInterfaceIndex(net.ParseIP(ip)); i != want {
+			t.Fatalf("Expected listenInterfaceIndex(%s) to return %d, got %d", ip, want, i)
+		}
+	}
+}
+
+func TestServiceListenerCloseError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	l := &serviceListener{Listener: ln, op: &RegisterOp{}}
+	l.setErr(ErrNameConflict)
+	l.setErr(ErrUnknown)
+	if err := l.Close(); !errors.Is(err, ErrNameConflict) {
+		t.Fatalf("Expected Close to return the registration error, got: %v", err)
+	}
+}
+
+func TestHealthyRegistrationThresholds(t *testing.T) {
+	type transition struct {
+		err     error
+		healthy bool
+	}
+	ch := make(chan transition, 1)
+	checks := make(chan error)
+	// Without a callback the RegisterOp fails to start without contacting the daemon.
+	op := NewRegisterOp("go-dnssd-test", "_go-dnssd-test._tcp", 0xCAFE, nil)
+	h := NewHealthyRegistration(op, func(ctx context.Context) error {
+		select {
+		case err := <-checks:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, func(h *HealthyRegistration, err error, healthy bool) {
+		ch <- transition{err, healthy}
+	})
+	for _, d := range []time.Duration{0, -time.Second} {
+		if err := h.SetInterval(d); err != ErrBadInterval {
+			t.Fatalf("Expected ErrBadInterval setting interval %v, got: %v", d, err)
+		}
+	}
+	h.SetInterval(100 * time.Millisecond)
+	h.SetPassThreshold(2)
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Stop()
+	checks <- nil
+	// Each send waits for the next check, by which time the previous
+	// result has been recorded.
+	checks <- errors.New("check failed")
+	select {
+	case tr := <-ch:
+		t.Fatalf("Unexpected transition: %v", tr)
+	default:
+	}
+	checks <- nil
+	checks <- nil
+	if tr := <-ch; tr.err != ErrMissingCallback || tr.healthy {
+		t.Fatalf("Expected start failure to be reported, got %v", tr)
+	}
+	if h.Healthy() {
+		t.Fatal("Expected registration that failed to start to be unhealthy")
+	}
+}
+
+func TestHealthyRegistrationZeroInterval(t *testing.T) {
+	checked := make(chan struct{}, 1)
+	h := &HealthyRegistration{
+		op: &RegisterOp{},
+		check: func(ctx context.Context) error {
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+		callback: func(*HealthyRegistration, error, bool) {},
+	}
+	if err := h.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer h.Stop()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Fatal("Health check didn't run")
+	}
+}
+
+func TestFileNameStore(t *testing.T) {
+	s := NewFileNameStore(filepath.Join(t.TempDir(), "names.json"))
+	if name, err := s.LookupName("Build Agent", "_http._tcp", "local."); err != nil || name != "" {
+		t.Fatalf("Expected empty store to return nothing, got %q, %v", name, err)
+	}
+	if err := s.StoreName("Build Agent", "_http._tcp", "local.", "Build Agent (2)"); err != nil {
+		t.Fatalf("StoreName failed: %v", err)
+	}
+	s = NewFileNameStore(s.path)
+	if name, err := s.LookupName("Build Agent", "_HTTP._tcp", "Local"); err != nil || name != "Build Agent (2)" {
+		t.Fatalf("Expected stored name, got %q, %v", name, err)
+	}
+	if name, _ := s.LookupName("Build Agent", "_ssh._tcp", "local."); name != "" {
+		t.Fatalf("Expected no name for another type, got %q", name)
+	}
+	for _, domain := range []string{"", "example.com."} {
+		if name, _ := s.LookupName("Build Agent", "_http._tcp", domain); name != "" {
+			t.Fatalf("Expected no name for domain %q, got %q", domain, name)
+		}
+	}
+}
+
+type failingNameStore struct{}
+
+func (failingNameStore) LookupName(name, serviceType, domain string) (string, error) {
+	return "", nil
+}
+
+func (failingNameStore) StoreName(name, serviceType, domain, registered string) error {
+	return errors.New("read-only")
+}
+
+func TestNameStoreErrorObserved(t *testing.T) {
+	o := NewExpvarObserver("dnssd_name_store_test")
+	SetObserver(o)
+	defer SetObserver(nil)
+	op := NewRegisterOp("go-dnssd-store", "_go-dnssd-test._tcp", 9, func(*RegisterOp, error, bool, string, string, string) {})
+	op.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	op.SetNameStore(failingNameStore{})
+	// Being registered under another name has it stored.
+	op.handleResult(true, "go-dnssd-store (2)", "_go-dnssd-test._tcp.", "local.")
+	drainCallbacks()
+	if n := o.nameStoreErrors.Value(); n != 1 {
+		t.Fatalf("Expected 1 name store error, got %d", n)
+	}
+}
+
+func TestRegisterOpCopyFor(t *testing.T) {
+	op := NewRegisterOp("go", "_go-dnssd._tcp", 9, nil)
+	op.SetTXTPair("a", "b")
+	op.AddSubtype("_sub")
+	op.SetNoAutoRename(true)
+	op.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	op.SetHost("host.local.")
+	op.SetSkipValidation(true)
+	store := NewFileNameStore(filepath.Join(t.TempDir(), "names.json"))
+	op.SetNameStore(store)
+	op.SetConflictResolver(func(attempt int, name string) (string, bool) { return "resolved", true })
+	c := op.copyFor("example.com.", nil)
+	if c.Name() != "go" || c.Type() != "_go-dnssd._tcp" || c.Port() != 9 || c.Domain() != "example.com." || c.Host() != "host.local." {
+		t.Fatalf("Copy has unexpected name, type, port, domain or host: %q %q %d %q %q", c.Name(), c.Type(), c.Port(), c.Domain(), c.Host())
+	}
+	if v, _ := c.TXT().Get("a"); string(v) != "b" || len(c.Subtypes()) != 1 || !c.NoAutoRename() || c.InterfaceIndex() != InterfaceIndexLocalOnly {
+		t.Fatal("Copy didn't retain TXT record, subtypes or flags")
+	}
+	if !c.SkipValidation() {
+		t.Fatal("Copy didn't retain SkipValidation")
+	}
+	if c.nameStore != store {
+		t.Fatal("Copy didn't retain name store")
+	}
+	if c.conflict.resolver == nil {
+		t.Fatal("Copy didn't retain conflict resolver")
+	} else if name, _ := c.conflict.resolver(1, "go"); name != "resolved" {
+		t.Fatalf("Copy has unexpected conflict resolver, returned %q", name)
+	}
+	op.SetTXTPair("a", "c")
+	if v, _ := c.TXT().Get("a"); string(v) != "b" {
+		t.Fatal("Copy shares TXT record with original")
+	}
+}
+
+func TestSplitEnumeratedType(t *testing.T) {
+	for _, c := range [][5]string{
+		{"_http", "_tcp.local.", ".", "_http._tcp", "local."},
+		{"_ipp", "_tcp.", "example.com.", "_ipp._tcp", "example.com."},
+		{"_sleep-proxy", "_udp", "local.", "_sleep-proxy._udp", "local."},
+	} {
+		stype, domain := splitEnumeratedType(c[0], c[1], c[2])
+		if stype != c[3] || domain != c[4] {
+			t.Fatalf("splitEnumeratedType(%q, %q, %q) returned %q, %q", c[0], c[1], c[2], stype, domain)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for s, want := range map[string]bool{
+		"_http._tcp":               true,
+		"_http._tcp.":              true,
+		"_go-dnssd-test._UDP":      true,
+		"_x2._tcp":                 true,
+		"_printer._sub._http._tcp": false,
+		"http._tcp":                false,
+		"_http._sctp":              false,
+		"_http._tcp.local":         false,
+		"_abcdefghijklmnop._tcp":   false,
+		"_-http._tcp":              false,
+		"_ht--tp._tcp":             false,
+		"_123._tcp":                false,
+		"_ht_tp._tcp":              false,
+	} {
+		if validServiceType(s, false) != want {
+			t.Fatalf("Expected validServiceType(%q) to return %v", s, want)
+		}
+	}
+	if !validServiceType("_printer._sub._http._tcp", true) {
+		t.Fatal("Expected subtype to be accepted")
+	}
+	op := NewRegisterOp(strings.Repeat("é", 31), "_http._tcp,_printer", 80, nil)
+	op.SetDomain("example.com.")
+	if err := op.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, c := range []struct {
+		f     func(*RegisterOp) error
+		field string
+		err   error
+	}{
+		{func(o *RegisterOp) error { return o.SetName(strings.Repeat("é", 32)) }, FieldInstanceName, ErrBadInstanceName},
+		{func(o *RegisterOp) error { return o.SetName("a\nb") }, FieldInstanceName, ErrBadInstanceName},
+		{func(o *RegisterOp) error { return o.SetType("_http") }, FieldServiceType, ErrBadServiceType},
+		{func(o *RegisterOp) error { return o.SetType("_http._tcp,_a.b") }, FieldSubtype, ErrBadSubtype},
+		{func(o *RegisterOp) error { return o.SetDomain("a..b") }, FieldDomain, ErrBadDomain},
+		{func(o *RegisterOp) error { return o.SetHost(strings.Repeat("a", 64) + ".local") }, FieldHost, ErrBadDomain},
+		{func(o *RegisterOp) error { return o.SetPort(65536) }, FieldPort, ErrBadPort},
+	} {
+		op := NewRegisterOp("", "_http._tcp", 80, func(*RegisterOp, error, bool, string, string, string) {})
+		err := c.f(op)
+		if err == nil {
+			err = op.Start()
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Field != c.field || !errors.Is(err, c.err) {
+			t.Fatalf("Expected %s validation error wrapping %v, got: %v", c.field, c.err, err)
+		}
+	}
+	bop := NewBrowseOp("_http._tcp", nil)
+	rop := NewResolveOp(0, "a", "_http._tcp", "local.", nil)
+	qop := NewQueryOp(0, "example.com.", 1, 1, nil)
+	for _, f := range []func() error{
+		func() error { return op.SetDomain("ü!.com") },
+		func() error { return bop.SetDomain("ü!.com") },
+		func() error { return rop.SetDomain("ü!.com") },
+		func() error { return qop.SetName("ü!.com") },
+	} {
+		var verr *ValidationError
+		if err := f(); !errors.As(err, &verr) || !errors.Is(err, ErrBadDomain) {
+			t.Fatalf("Expected domain validation error, got: %v", err)
+		}
+	}
+	if op.Domain() != "example.com." || bop.Domain() != "" || rop.Domain() != "local." || qop.Name() != "example.com." {
+		t.Fatalf("Expected rejected values to be discarded, got %q, %q, %q, %q", op.Domain(), bop.Domain(), rop.Domain(), qop.Name())
+	}
+	if err := NewResolveOp(0, "a", "_http._tcp", "ü!.com", nil).Validate(); !errors.Is(err, ErrBadDomain) {
+		t.Fatalf("Expected ResolveOp with bad domain to be invalid, got: %v", err)
+	}
+	if err := NewQueryOp(0, "ü!.com", 1, 1, nil).Validate(); !errors.Is(err, ErrBadDomain) {
+		t.Fatalf("Expected QueryOp with bad name to be invalid, got: %v", err)
+	}
+	dir := NewServiceDirectory("_http._tcp", func(*ServiceDirectory, error, ServiceEvent) {})
+	dir.SetDomain("ü!.com")
+	if err := dir.Start(); !errors.Is(err, ErrBadDomain) {
+		dir.Stop()
+		t.Fatalf("Expected ServiceDirectory with bad domain to fail to start, got: %v", err)
+	}
+	// Both ops check the length of the normalized name.
+	decomposed := strings.Repeat("e\u0301", 31)
+	if err := NewRegisterOp(decomposed, "_http._tcp", 80, nil).Validate(); err != nil {
+		t.Fatalf("Unexpected error registering decomposed name: %v", err)
+	}
+	rop = NewResolveOp(0, decomposed, "_http._tcp", "local", nil)
+	if err := rop.Validate(); err != nil {
+		t.Fatalf("Unexpected error resolving decomposed name: %v", err)
+	}
+	if rop.Name() != decomposed {
+		t.Fatalf("Expected ResolveOp name to be left as given, got %q", rop.Name())
+	}
+	decomposed += "e\u0301"
+	if err := NewRegisterOp(decomposed, "_http._tcp", 80, nil).Validate(); !errors.Is(err, ErrBadInstanceName) {
+		t.Fatalf("Expected long decomposed name to be invalid to register, got: %v", err)
+	}
+	if err := NewResolveOp(0, decomposed, "_http._tcp", "local", nil).Validate(); !errors.Is(err, ErrBadInstanceName) {
+		t.Fatalf("Expected long decomposed name to be invalid to resolve, got: %v", err)
+	}
+	if err := NewResolveOp(0, "", "_http._tcp", "local", nil).Validate(); !errors.Is(err, ErrBadInstanceName) {
+		t.Fatalf("Expected ResolveOp with empty name to be invalid, got: %v", err)
+	}
+	if err := NewBrowseOp(ServiceTypeEnumerationType, nil).Validate(); err != nil {
+		t.Fatalf("Expected service type enumeration to be valid, got: %v", err)
+	}
+	if err := NewQueryOp(0, "", 1, 1, nil).Validate(); !errors.Is(err, ErrBadDomain) {
+		t.Fatalf("Expected QueryOp with empty name to be invalid, got: %v", err)
 	}
 }
 
@@ -122,6 +935,94 @@ func TestQueryStartStop(t *testing.T) {
 	StartStopHelper(t, NewQueryOp(0, "golang.org.", 1, 1, f))
 }
 
+func TestQueryStopReleasesOp(t *testing.T) {
+	f := func(op *QueryOp, err error, add bool, interfaceIndex int, fullname string, rrtype, rrclass uint16, rdata []byte, ttl uint32) {
+	}
+	op := NewQueryOp(0, "golang.org.", 1, 1, f)
+	polled := func() bool {
+		for _, p := range pollServer.ops() {
+			if p.p == op {
+				return true
+			}
+		}
+		return false
+	}
+	if err := op.Start(); err != nil {
+		t.Fatalf("Couldn't start op: %v", err)
+	}
+	if !polled() {
+		t.Fatal("Started op isn't being polled")
+	}
+	op.Stop()
+	if polled() {
+		t.Fatal("Stopped op is still being polled")
+	}
+	// Restarting would fail with ErrStarted if Stop hadn't released the op.
+	if err := op.Start(); err != nil {
+		t.Fatalf("Couldn't restart op: %v", err)
+	}
+	op.Stop()
+}
+
+func TestQueryFlags(t *testing.T) {
+	op := &QueryOp{}
+	setters := []struct {
+		flag uint32
+		set  func(bool) error
+		get  func() bool
+	}{
+		{_FlagsForceMulticast, op.SetForceMulticast, op.ForceMulticast},
+		{_FlagsLongLivedQuery, op.SetLongLivedQuery, op.LongLivedQuery},
+		{_FlagsReturnIntermediates, op.SetReturnIntermediates, op.ReturnIntermediates},
+		{_FlagsTimeout, op.SetTimeout, op.Timeout},
+		{_FlagsSuppressUnusable, op.SetSuppressUnusable, op.SuppressUnusable},
+	}
+	for _, s := range setters {
+		if err := s.set(true); err != nil {
+			t.Fatalf("Unexpected error setting flag %#x: %v", s.flag, err)
+		}
+		if !s.get() || op.flags != s.flag {
+			t.Fatalf("Expected flags %#x, got %#x", s.flag, op.flags)
+		}
+		if err := s.set(false); err != nil {
+			t.Fatalf("Unexpected error clearing flag %#x: %v", s.flag, err)
+		}
+		if s.get() || op.flags != 0 {
+			t.Fatalf("Expected flags 0 after clearing %#x, got %#x", s.flag, op.flags)
+		}
+	}
+	op.started = true
+	if err := op.SetTimeout(true); err != ErrStarted {
+		t.Fatalf("Expected ErrStarted, got: %v", err)
+	}
+}
+
+func TestSubtypes(t *testing.T) {
+	op := &RegisterOp{}
+	for _, s := range []string{"", "_a.b", "_a,b", `_a\b`, "_" + strings.Repeat("a", 63)} {
+		if err := op.AddSubtype(s); err != ErrBadSubtype {
+			t.Fatalf("Expected ErrBadSubtype adding %q, got: %v", s, err)
+		}
+	}
+	for _, s := range []string{"_printer", "_scanner", "_printer"} {
+		if err := op.AddSubtype(s); err != nil {
+			t.Fatalf("Unexpected error adding %q: %v", s, err)
+		}
+	}
+	if s := strings.Join(op.Subtypes(), ","); s != "_printer,_scanner" {
+		t.Fatalf(`Expected subtypes "_printer,_scanner", got %q`, s)
+	}
+	for in, out := range map[string]string{
+		"_http._tcp.":               "_http._tcp.",
+		"_printer._sub._http._tcp.": "_http._tcp.",
+		"_printer._sub._http._tcp":  "_http._tcp",
+	} {
+		if s := parentServiceType(in); s != out {
+			t.Fatalf("Expected parentServiceType(%q) to return %q, got %q", in, out, s)
+		}
+	}
+}
+
 func TestRegisterPort(t *testing.T) {
 	sport := 0xCAFE
 	sname := "go-dnssd-test"
@@ -134,7 +1035,7 @@ func TestRegisterPort(t *testing.T) {
 		default:
 		}
 	}
-	resop := NewResolveOp(InterfaceIndexLocalOnly, sname, stype, sdom, func(op *ResolveOp, err error, host string, port int, txt map[string]string) {
+	resop := NewResolveOp(InterfaceIndexLocalOnly, sname, stype, sdom, func(op *ResolveOp, err error, host string, port int, txt TXTRecord) {
 		switch {
 		case err != nil:
 			senderr("resolve callback - error: %s", err)
@@ -188,3 +1089,444 @@ func TestRegisterPort(t *testing.T) {
 		t.Fatal(errmsg)
 	}
 }
+
+func TestNormalizeName(t *testing.T) {
+	if NormalizeName("Cafe\u0301") != "Caf\u00e9" {
+		t.Fatal("Expected decomposed name to be normalized to NFC")
+	}
+	if instanceKey("Bu\u0308ro", "_http._tcp", "local.") != instanceKey("B\u00fcro", "_http._tcp", "local.") {
+		t.Fatal("Expected instance keys of decomposed and precomposed names to match")
+	}
+	long := strings.Repeat("a", 62) + "\u00e9"
+	if s := NormalizeInstanceName(long); s != strings.Repeat("a", 62) {
+		t.Fatalf("Expected truncation on a rune boundary, got %q", s)
+	}
+	// A base character followed by a combining mark with no precomposed
+	// form isn't separated from the mark.
+	long = strings.Repeat("a", 61) + "q\u0307"
+	if s := truncateName(long, 63); s != strings.Repeat("a", 61) {
+		t.Fatalf("Expected truncation on a normalization boundary, got %q", s)
+	}
+	for s, want := range map[string]string{
+		"example.com.":                "example.com.",
+		"bücher.example":              "xn--bcher-kva.example",
+		"Büro._http._tcp.münchen.de.": "Büro._http._tcp.xn--mnchen-3ya.de.",
+		"Büro._http._tcp.local.":      "Büro._http._tcp.local.",
+		"_http._tcp.bücher.example":   "_http._tcp.xn--bcher-kva.example",
+		"münchen.local":               "münchen.local",
+	} {
+		if got, err := domainToASCII(s); err != nil || got != want {
+			t.Fatalf("domainToASCII(%q) returned %q, %v, expected %q", s, got, err, want)
+		}
+	}
+	op := NewQueryOp(0, "bücher.example.", 1, 1, nil)
+	if n := op.Name(); n != "xn--bcher-kva.example." {
+		t.Fatalf("Expected QueryOp name to be converted, got %q", n)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	sname, stype := "go-dnssd-discover", "_go-dnssd-test._tcp"
+	regch := make(chan error, 1)
+	regop := NewRegisterOp(sname, stype, 0xCAFE, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		select {
+		case regch <- err:
+		default:
+		}
+	})
+	regop.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	regop.SetTXTPair("a", "b")
+	if err := regop.Start(); err != nil {
+		t.Fatalf("register op start failed: %s", err)
+	}
+	defer regop.Stop()
+	if err := <-regch; err != nil {
+		t.Fatalf("register callback - error: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	services, err := Discover(ctx, stype, "local", &DiscoverOptions{
+		InterfaceIndex: InterfaceIndexLocalOnly,
+		QuietPeriod:    500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Discover failed: %v", err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("Expected 1 service, got: %v", services)
+	}
+	if s := services[0]; s.Name != sname || s.Port != 0xCAFE || s.TXT.Map()["a"] != "b" {
+		t.Fatalf("Unexpected service: %+v", s)
+	}
+}
+
+type countingObserver struct {
+	ExpvarObserver
+	delivered chan struct{}
+}
+
+func (o *countingObserver) CallbackDelivered() {
+	o.ExpvarObserver.CallbackDelivered()
+	select {
+	case o.delivered <- struct{}{}:
+	default:
+	}
+}
+
+func TestObserver(t *testing.T) {
+	o := &countingObserver{*NewExpvarObserver("dnssd_test"), make(chan struct{}, 1)}
+	SetObserver(o)
+	defer SetObserver(nil)
+	queueCallback(func() {})
+	select {
+	case <-o.delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Callback delivery wasn't observed")
+	}
+	if n := o.callbacks.Value(); n < 1 {
+		t.Fatalf("Expected a callback to have been delivered, got %d", n)
+	}
+	o.OpStarted(OpKindBrowse)
+	o.OpStarted(OpKindBrowse)
+	o.OpError(OpKindBrowse, ErrUnknown)
+	o.FirstResult(OpKindBrowse, time.Second)
+	if s := o.active.Get(OpKindBrowse).String(); s != "1" {
+		t.Fatalf("Expected 1 active browse op, got %s", s)
+	}
+	if s := o.resultSeconds.Get(OpKindBrowse).String(); s != "1" {
+		t.Fatalf("Expected 1 second of latency, got %s", s)
+	}
+}
+
+type registerEvent struct {
+	err  error
+	add  bool
+	name string
+}
+
+// startConflictOp starts a RegisterOp with the given conflict resolver and
+// waits for it to be registered. Its later callbacks are sent to events.
+func startConflictOp(t *testing.T, name string, f ConflictResolver) (*RegisterOp, <-chan registerEvent) {
+	events := make(chan registerEvent, 8)
+	op := NewRegisterOp(name, "_go-dnssd-test._tcp", 9, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		select {
+		case events <- registerEvent{err, add, name}:
+		default:
+		}
+	})
+	op.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	op.SetConflictResolver(f)
+	if err := op.Start(); err != nil {
+		t.Fatalf("register op start failed: %s", err)
+	}
+	if e := nextRegisterEvent(t, events); e.err != nil || !e.add {
+		op.Stop()
+		t.Fatalf("Expected registration, got: %+v", e)
+	}
+	return op, events
+}
+
+func nextRegisterEvent(t *testing.T, events <-chan registerEvent) registerEvent {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Register callback wasn't called")
+	}
+	return registerEvent{}
+}
+
+func TestConflictResolverRenames(t *testing.T) {
+	var attempts []int
+	op, events := startConflictOp(t, "go-dnssd-conflict", func(attempt int, name string) (string, bool) {
+		attempts = append(attempts, attempt)
+		return name + " (renamed)", true
+	})
+	defer op.Stop()
+	injectConflict(op)()
+	e := nextRegisterEvent(t, events)
+	if e.err != nil || !e.add || e.name != "go-dnssd-conflict (renamed)" {
+		t.Fatalf("Expected re-registration under the new name, got: %+v", e)
+	}
+	if len(attempts) != 1 || attempts[0] != 1 || !op.Active() {
+		t.Fatalf("Expected 1 attempt leaving the op active, got %v (active: %v)", attempts, op.Active())
+	}
+}
+
+func TestConflictResolverRegistrationFails(t *testing.T) {
+	op, events := startConflictOp(t, "go-dnssd-conflict-fail", func(attempt int, name string) (string, bool) {
+		// Too long to be registered.
+		return strings.Repeat("a", 64), true
+	})
+	defer op.Stop()
+	injectConflict(op)()
+	if e := nextRegisterEvent(t, events); e.err == nil {
+		t.Fatalf("Expected re-registration to fail, got: %+v", e)
+	}
+	if op.Active() {
+		t.Fatal("Expected the op to stop after re-registration failed")
+	}
+}
+
+func TestConflictResolverGivesUp(t *testing.T) {
+	o := NewExpvarObserver("dnssd_conflict_test")
+	SetObserver(o)
+	defer SetObserver(nil)
+	attempts := 0
+	op, events := startConflictOp(t, "go-dnssd-conflict-give-up", func(attempt int, name string) (string, bool) {
+		attempts++
+		return "", false
+	})
+	defer op.Stop()
+	injectConflict(op)()
+	if e := nextRegisterEvent(t, events); e.err != ErrNameConflict {
+		t.Fatalf("Expected ErrNameConflict, got: %+v", e)
+	}
+	if attempts != 1 || op.Active() {
+		t.Fatalf("Expected the op to stop after 1 attempt, got %d attempts (active: %v)", attempts, op.Active())
+	}
+	if v := o.errors.Get(OpKindRegister); v == nil || v.String() != "1" {
+		t.Fatalf("Expected 1 register error, got %v", v)
+	}
+	if v := o.started.Get(OpKindRegister); v == nil || v.String() != "1" {
+		t.Fatalf("Expected 1 register op to be reported started, got %v", v)
+	}
+	if v := o.active.Get(OpKindRegister); v == nil || v.String() != "0" {
+		t.Fatalf("Expected 0 active register ops, got %v", v)
+	}
+}
+
+func TestConflictResolverStopped(t *testing.T) {
+	op, events := startConflictOp(t, "go-dnssd-conflict-stop", func(attempt int, name string) (string, bool) {
+		return name + " (renamed)", true
+	})
+	release := injectConflict(op)
+	op.Stop()
+	release()
+	drainCallbacks()
+	if op.Active() {
+		t.Fatal("Op restarted after being stopped")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("Unexpected callback after Stop: %+v", e)
+	default:
+	}
+}
+
+// injectConflict has op handle a name conflict as if the daemon had reported
+// one. The callback goroutine is held, so the conflict isn't resolved until
+// release is called.
+func injectConflict(op *RegisterOp) (release func()) {
+	hold := make(chan struct{})
+	queueCallback(func() { <-hold })
+	pollServer.m.external.Lock()
+	pollServer.stopPoll()
+	pollServer.m.internal.Lock()
+	op.handleError(ErrNameConflict)
+	pollServer.m.internal.Unlock()
+	pollServer.startPoll()
+	pollServer.m.external.Unlock()
+	return func() { close(hold) }
+}
+
+// drainCallbacks returns once the callbacks already queued have run.
+func drainCallbacks() {
+	done := make(chan struct{})
+	queueCallback(func() { close(done) })
+	<-done
+}
+
+func TestObserverStopDuringConflict(t *testing.T) {
+	o := NewExpvarObserver("dnssd_conflict_stop_test")
+	SetObserver(o)
+	defer SetObserver(nil)
+	op := NewRegisterOp("go-dnssd-conflict-stop", "_go-dnssd-test._tcp", 9, func(*RegisterOp, error, bool, string, string, string) {})
+	op.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	resolved := false
+	op.SetConflictResolver(func(attempt int, name string) (string, bool) {
+		resolved = true
+		return name + " (2)", true
+	})
+	if err := op.Start(); err != nil {
+		t.Fatalf("register op start failed: %s", err)
+	}
+	release := injectConflict(op)
+	op.Stop()
+	release()
+	drainCallbacks()
+	if !resolved {
+		t.Fatal("Conflict resolver wasn't called")
+	}
+	if op.Active() {
+		t.Fatal("Op restarted after being stopped")
+	}
+	if v := o.active.Get(OpKindRegister); v == nil || v.String() != "0" {
+		t.Fatalf("Expected 0 active register ops, got %v", v)
+	}
+	if v := o.stopped.Get(OpKindRegister); v == nil || v.String() != "1" {
+		t.Fatalf("Expected 1 stopped register op, got %v", v)
+	}
+}
+
+func TestActiveOpsHandler(t *testing.T) {
+	for _, c := range []struct {
+		url, accept, contentType string
+	}{
+		{"/", "", "text/html; charset=utf-8"},
+		{"/?format=json", "", "application/json"},
+		{"/", "application/json", "application/json"},
+	} {
+		r := httptest.NewRequest("GET", c.url, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		ActiveOpsHandler().ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Fatalf("Expected %s with Accept %q to return %s, got %s", c.url, c.accept, c.contentType, ct)
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %s to return status %d, got %d", c.url, http.StatusOK, w.Code)
+		}
+		if c.contentType == "application/json" {
+			var ops []OpInfo
+			if err := json.Unmarshal(w.Body.Bytes(), &ops); err != nil {
+				t.Fatalf("Couldn't decode JSON: %v", err)
+			}
+		}
+	}
+	p := &BrowseOp{}
+	p.SetType("_http._tcp")
+	p.SetDomain("local.")
+	if params := opParams(p); params["type"] != "_http._tcp" || params["domain"] != "local." || len(params) != 2 {
+		t.Fatalf("Unexpected browse op params: %v", params)
+	}
+}
+
+func TestResolverFromCallback(t *testing.T) {
+	done := make(chan error, 1)
+	regop := NewRegisterOp("go-dnssd-lookup", "_go-dnssd-test._tcp", 0xCAFE, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		if err != nil || !add {
+			select {
+			case done <- err:
+			default:
+			}
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r := &Resolver{InterfaceIndex: InterfaceIndexLocalOnly}
+		_, addrs, err := r.LookupSRV(ctx, "", "", name+"."+serviceType+domain)
+		if err == nil && (len(addrs) != 1 || addrs[0].Port != 0xCAFE) {
+			err = fmt.Errorf("unexpected SRV records: %v", addrs)
+		}
+		select {
+		case done <- err:
+		default:
+		}
+	})
+	regop.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	if err := regop.Start(); err != nil {
+		t.Fatalf("register op start failed: %s", err)
+	}
+	defer regop.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Lookup from callback failed: %v", err)
+	}
+}
+
+func TestDialFromBrowseCallback(t *testing.T) {
+	sname, stype := "go-dnssd-dial", "_go-dnssd-test._tcp"
+	ln, err := Listen("tcp", ":0", sname, stype, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	done := make(chan error, 1)
+	bop := NewBrowseOp(stype, func(op *BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+		if err == nil && (!add || name != sname) {
+			return
+		}
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var c net.Conn
+			if c, err = DialService(ctx, "tcp", name, serviceType, domain); err == nil {
+				c.Close()
+			}
+		}
+		select {
+		case done <- err:
+		default:
+		}
+	})
+	if err := bop.Start(); err != nil {
+		t.Fatalf("browse op start failed: %s", err)
+	}
+	defer bop.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Dial from browse callback failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Dial from browse callback didn't complete")
+	}
+}
+
+func TestDiscoverFromCallback(t *testing.T) {
+	sname, stype := "go-dnssd-discover-cb", "_go-dnssd-test._tcp"
+	type result struct {
+		services []Service
+		elapsed  time.Duration
+		err      error
+	}
+	done := make(chan result, 1)
+	regop := NewRegisterOp(sname, stype, 0xCAFE, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		var r result
+		if r.err = err; err == nil {
+			// ctx ends before the quiet period does.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			start := time.Now()
+			r.services, r.err = Discover(ctx, stype, "local", &DiscoverOptions{
+				InterfaceIndex: InterfaceIndexLocalOnly,
+				QuietPeriod:    time.Minute,
+			})
+			r.elapsed = time.Since(start)
+		}
+		select {
+		case done <- r:
+		default:
+		}
+	})
+	regop.SetInterfaceIndex(InterfaceIndexLocalOnly)
+	if err := regop.Start(); err != nil {
+		t.Fatalf("register op start failed: %s", err)
+	}
+	defer regop.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("Discover failed: %v", r.err)
+		}
+		if len(r.services) != 1 || r.services[0].Name != sname {
+			t.Fatalf("Expected %q, got: %v", sname, r.services)
+		}
+		if r.elapsed > 2*time.Second {
+			t.Fatalf("Expected Discover to return by ctx's deadline, took %v", r.elapsed)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Discover from callback didn't complete")
+	}
+}
@@ -0,0 +1,117 @@
+package dnssd
+
+import "unsafe"
+
+// DomainEnumCallbackFunc is called when an error occurs or a domain is added or removed.
+// isDefault indicates the domain is the default browse or registration domain.
+type DomainEnumCallbackFunc func(op *DomainEnumOp, err error, add bool, interfaceIndex int, domain string, isDefault bool)
+
+// DomainEnumOp represents a query for the domains recommended for browsing or registration.
+type DomainEnumOp struct {
+	baseOp
+	registration bool
+	callback     DomainEnumCallbackFunc
+}
+
+// NewDomainEnumOp creates a new DomainEnumOp with the given callback set.
+// By default the op enumerates browse domains.
+func NewDomainEnumOp(f DomainEnumCallbackFunc) *DomainEnumOp {
+	op := &DomainEnumOp{}
+	op.SetCallback(f)
+	return op
+}
+
+// StartDomainEnumOp returns the equivalent of calling NewDomainEnumOp and Start().
+func StartDomainEnumOp(f DomainEnumCallbackFunc) (*DomainEnumOp, error) {
+	op := NewDomainEnumOp(f)
+	return op, op.Start()
+}
+
+// RegistrationDomains indicates whether registration domains are enumerated rather than browse domains.
+func (o *DomainEnumOp) RegistrationDomains() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.registration
+}
+
+// SetRegistrationDomains sets whether registration domains are enumerated rather than browse domains.
+func (o *DomainEnumOp) SetRegistrationDomains(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.registration = e
+	return nil
+}
+
+// SetCallback sets the function to call when an error occurs or a domain is added or removed.
+func (o *DomainEnumOp) SetCallback(f DomainEnumCallbackFunc) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.callback = f
+	return nil
+}
+
+// Start begins enumerating domains.
+func (o *DomainEnumOp) Start() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	if o.callback == nil {
+		return ErrMissingCallback
+	}
+	err := pollServer.startOp(o)
+	o.started = err == nil || err == ErrStarted
+	return err
+}
+
+func (o *DomainEnumOp) init(sharedref uintptr) (ref uintptr, err error) {
+	ref = sharedref
+	o.setFlag(_FlagsShareConnection, ref != 0)
+	o.setFlag(_FlagsBrowseDomains, !o.registration)
+	o.setFlag(_FlagsRegistrationDomains, o.registration)
+	if err = enumDomainsStart(&ref, o.flags, o.interfaceIndexC(), unsafe.Pointer(o)); err != nil {
+		ref = 0
+	}
+	return
+}
+
+// Stop stops the operation.
+func (o *DomainEnumOp) Stop() {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if !o.started {
+		return
+	}
+	o.started = false
+	pollServer.stopOp(o)
+}
+
+func (o *DomainEnumOp) handleError(e error) {
+	if !o.started {
+		return
+	}
+	o.started = false
+	pollServer.failOp(o, e)
+	queueCallback(func() { o.callback(o, e, false, 0, "", false) })
+}
+
+func dnssdEnumDomainsCallback(sdRef unsafe.Pointer, flags, interfaceIndex uint32, err int32, domain, ctx unsafe.Pointer) {
+	o := (*DomainEnumOp)(ctx)
+	if e := getError(err); e != nil {
+		o.handleError(e)
+	} else {
+		pollServer.countResult(o)
+		a := flags&_FlagsAdd != 0
+		def := flags&_FlagsDefault != 0
+		i := interfaceIndexGo(interfaceIndex)
+		d := cStringToString(domain)
+		queueCallback(func() { o.callback(o, nil, a, i, d, def) })
+	}
+}
@@ -17,6 +17,38 @@ var ErrTXTStringLen = errors.New("TXT string may not exceed 255 bytes")
 // ErrTXTLen is returned when setting a TXT pair that would exceed the 65,535 byte TXT record limit.
 var ErrTXTLen = errors.New("TXT size may not exceed 65535 bytes")
 
+// ErrBadServiceType is returned when validating a service type that isn't of the form "_name._tcp" or "_name._udp",
+// where name is an RFC 6335 service name of at most 15 letters, digits and hyphens.
+var ErrBadServiceType = errors.New("service type must be an RFC 6335 service name followed by _tcp or _udp")
+
+// ErrBadInstanceName is returned when validating a service instance name that isn't at most 63 bytes of UTF-8
+// without control characters.
+var ErrBadInstanceName = errors.New("instance name must be at most 63 bytes of UTF-8 without control characters")
+
+// ErrBadDomain is returned when validating a malformed domain name.
+var ErrBadDomain = errors.New("malformed domain name")
+
+// ErrBadPort is returned when validating a port outside the range 0 to 65535.
+var ErrBadPort = errors.New("port must be between 0 and 65535")
+
+// ErrBadInterval is returned when setting a health check interval that isn't positive.
+var ErrBadInterval = errors.New("interval must be positive")
+
+// ErrListenerAddr is returned by Listen when the listener's address isn't a TCP address.
+var ErrListenerAddr = errors.New("listener address is not a TCP address")
+
+// ErrTXTKey is returned when setting a TXT pair with an empty key or a key containing '='.
+var ErrTXTKey = errors.New("TXT key must be non-empty and may not contain '='")
+
+// ErrTXTMalformed is returned when decoding a TXT record containing a string that exceeds the record's length.
+var ErrTXTMalformed = errors.New("TXT string exceeds record length")
+
+// ErrTXTTarget is returned when MarshalTXT or UnmarshalTXT is passed something other than a struct or a pointer to a struct.
+var ErrTXTTarget = errors.New("TXT value must be a struct or a pointer to a struct")
+
+// ErrBadSubtype is returned when setting a service subtype that is empty, exceeds 63 bytes or contains a period, comma or backslash.
+var ErrBadSubtype = errors.New("invalid service subtype")
+
 // Error structs meet the error interface and are returned when errors occur in the underlying C API.
 type Error struct {
 	n int32
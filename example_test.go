@@ -1,12 +1,15 @@
 package dnssd_test
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/andrewtj/dnssd"
+	"github.com/andrewtj/dnssd/rr"
 	"github.com/miekg/dns"
 )
 
@@ -59,6 +62,26 @@ func ExampleRegisterOp_proxy() {
 	op.Stop()
 }
 
+func ExampleRegisterOp_conflictResolver() {
+	suffix := "a1b2" // eg: derived from a MAC address
+	op := dnssd.NewRegisterOp("Kitchen Speaker", "_raop._tcp", 7000, ExampleRegisterCallbackFunc)
+	op.SetConflictResolver(func(attempt int, name string) (string, bool) {
+		switch attempt {
+		case 1:
+			return fmt.Sprintf("Kitchen Speaker [%s]", suffix), true
+		case 2, 3, 4:
+			return fmt.Sprintf("Kitchen Speaker [%s] %d", suffix, attempt), true
+		}
+		return "", false
+	})
+	if err := op.Start(); err != nil {
+		log.Printf("Failed to register service: %s", err)
+		return
+	}
+	// later...
+	op.Stop()
+}
+
 func ExampleBrowseCallbackFunc(op *dnssd.BrowseOp, err error, add bool, interfaceIndex int, name string, serviceType string, domain string) {
 	if err != nil {
 		// op is now inactive
@@ -94,13 +117,80 @@ func ExampleBrowseOp_domain() {
 	op.Stop()
 }
 
-func ExampleResolveCallbackFunc(op *dnssd.ResolveOp, err error, host string, port int, txt map[string]string) {
+func ExampleBrowseOp_subtype() {
+	op := dnssd.NewBrowseOp("_http._tcp", ExampleBrowseCallbackFunc)
+	if err := op.SetSubtype("_printer"); err != nil {
+		log.Printf("Failed to set subtype: %s", err)
+		return
+	}
+	if err := op.Start(); err != nil {
+		log.Printf("Failed to start browse operation: %s", err)
+		return
+	}
+	// later...
+	op.Stop()
+}
+
+func ExampleTypeBrowser() {
+	b := dnssd.NewTypeBrowser(func(b *dnssd.TypeBrowser, err error, add bool, interfaceIndex int, serviceType, domain string) {
+		if err != nil {
+			log.Printf("Type browse failed: %s", err)
+			return
+		}
+		log.Printf("Service type %s in %s (add: %v)", serviceType, domain, add)
+	})
+	b.SetInstanceCallback(ExampleBrowseCallbackFunc)
+	if err := b.Start(); err != nil {
+		log.Printf("Failed to start type browser: %s", err)
+		return
+	}
+	// later...
+	b.Stop()
+}
+
+func ExampleServiceDirectory() {
+	d, err := dnssd.StartServiceDirectory("_http._tcp", func(d *dnssd.ServiceDirectory, err error, e dnssd.ServiceEvent) {
+		if err != nil {
+			// d is now inactive
+			log.Printf("Service directory failed: %s", err)
+			return
+		}
+		log.Printf("Service “%s” %s: %s:%d %v", e.Service.Name, e.Type, e.Service.Host, e.Service.Port, e.Service.Addrs)
+	})
+	if err != nil {
+		log.Printf("Failed to start service directory: %s", err)
+		return
+	}
+	// later...
+	for _, s := range d.Snapshot() {
+		log.Printf("Service “%s” is at %s:%d", s.Name, s.Host, s.Port)
+	}
+	d.Stop()
+}
+
+func ExampleResolveCallbackFunc(op *dnssd.ResolveOp, err error, host string, port int, txt dnssd.TXTRecord) {
 	if err != nil {
 		// op is now inactive
 		log.Printf("Resolve operation failed: %s", err)
 		return
 	}
-	log.Printf("Resolved service to host %s port %d with meta info: %v", host, port, txt)
+	log.Printf("Resolved service to host %s port %d with meta info: %v", host, port, txt.Map())
+}
+
+func ExampleResolveResultCallbackFunc(op *dnssd.ResolveOp, err error, r dnssd.ResolveResult) {
+	if err != nil {
+		// op is now inactive
+		log.Printf("Resolve operation failed: %s", err)
+		return
+	}
+	zone := ""
+	if ifi, err := net.InterfaceByIndex(r.InterfaceIndex); err == nil {
+		zone = ifi.Name
+	}
+	log.Printf("Resolved %s to host %s port %d on interface %q", r.FullName, r.Host, r.Port, zone)
+	if !r.MoreComing {
+		op.Stop()
+	}
 }
 
 func ExampleResolveOp() {
@@ -160,6 +250,41 @@ func ExampleQueryCallbackFunc_unpackRR(op *dnssd.QueryOp, err error, add bool, i
 	log.Printf("Query operation on interface %d %s:\n%s", interfaceIndex, change, rr.String())
 }
 
+func ExampleQueryEventCallbackFunc(op *dnssd.QueryOp, err error, e dnssd.QueryEvent) {
+	if err != nil {
+		// op is now inactive
+		log.Printf("Query operation failed: %s", err)
+		return
+	}
+	switch rd := e.RData.(type) {
+	case *rr.SRV:
+		log.Printf("%s is at %s port %d (add: %v)", e.Name, rd.Target, rd.Port, e.Add)
+	default:
+		log.Printf("%s %s %s (add: %v)", e.Name, rr.TypeString(e.Type), rd, e.Add)
+	}
+}
+
+func ExampleRecordCache() {
+	cache := dnssd.NewRecordCache()
+	w, err := cache.Watch("golang.org.", 1, 1, func(w *dnssd.RecordWatch, err error, add bool, r dnssd.Record) {
+		if err != nil {
+			// w is now inactive
+			log.Printf("Watch failed: %s", err)
+			return
+		}
+		log.Printf("Record %s %v (added: %v)", r.Name, net.IP(r.Data), add)
+	})
+	if err != nil {
+		log.Printf("Failed to start watch: %s", err)
+		return
+	}
+	// later...
+	for _, r := range cache.Get("golang.org.", 1, 1) {
+		log.Printf("Cached %s %v", r.Name, net.IP(r.Data))
+	}
+	w.Stop()
+}
+
 func ExampleQueryOp() {
 	op := dnssd.NewQueryOp(0, "golang.org.", 1, 1, ExampleQueryCallbackFunc)
 	if err := op.Start(); err != nil {
@@ -169,3 +294,57 @@ func ExampleQueryOp() {
 	// later
 	op.Stop()
 }
+
+func ExampleMarshalTXT() {
+	type printerInfo struct {
+		Version int    `txt:"txtvers"`
+		Queue   string `txt:"rp,omitempty"`
+		Color   bool   `txt:"Color"`
+	}
+	txt, err := dnssd.MarshalTXT(printerInfo{Version: 1, Queue: "lp", Color: true})
+	if err != nil {
+		log.Printf("Failed to marshal TXT record: %s", err)
+		return
+	}
+	var info printerInfo
+	if err := dnssd.UnmarshalTXT(txt, &info); err != nil {
+		log.Printf("Failed to unmarshal TXT record: %s", err)
+		return
+	}
+	fmt.Printf("%+v\n", info)
+	// Output: {Version:1 Queue:lp Color:true}
+}
+
+func ExampleResolver() {
+	// hostLookuper is satisfied by both *net.Resolver and *dnssd.Resolver.
+	type hostLookuper interface {
+		LookupHost(ctx context.Context, host string) ([]string, error)
+	}
+	var r hostLookuper = &dnssd.Resolver{}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrs, err := r.LookupHost(ctx, "printer.local.")
+	if err != nil {
+		log.Printf("Lookup failed: %s", err)
+		return
+	}
+	log.Printf("printer.local. has addresses %v", addrs)
+}
+
+func ExampleDiscover() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	services, err := dnssd.Discover(ctx, "_http._tcp", "", nil)
+	if err != nil {
+		log.Printf("Discovery failed: %s", err)
+		return
+	}
+	for _, s := range services {
+		log.Printf("%s at %s:%d %v", s.Name, s.Host, s.Port, s.Addrs)
+	}
+}
+
+func ExampleSetObserver() {
+	// Publish counters at /debug/vars when serving http.DefaultServeMux.
+	dnssd.SetObserver(dnssd.NewExpvarObserver("dnssd"))
+}
@@ -0,0 +1,116 @@
+package dnssd
+
+import (
+	"expvar"
+	"time"
+)
+
+// ExpvarObserver is an Observer that publishes counters using the expvar
+// package. Per-op counters are maps keyed by the kind of op.
+//
+//	ops_active               ops started and not yet stopped
+//	ops_started              ops started
+//	ops_stopped              ops stopped by Stop
+//	op_errors                ops stopped by an error
+//	first_results            ops that received a result
+//	first_result_seconds     total latency to the first result
+//	callbacks                callbacks delivered
+//	callback_queue_depth     callbacks waiting to be delivered
+//	connections_established  shared connections established
+//	connections_lost         shared connections lost or not established
+//	name_store_errors        names a NameStore failed to store
+//	last_error               the most recent op, connection or name store error
+type ExpvarObserver struct {
+	active, started, stopped, errors *expvar.Map
+	results, resultSeconds           *expvar.Map
+	callbacks, queueDepth            *expvar.Int
+	established, lost                *expvar.Int
+	nameStoreErrors                  *expvar.Int
+	lastError                        *expvar.String
+}
+
+// NewExpvarObserver returns an ExpvarObserver that publishes its counters as
+// a map with the given name. Like expvar.Publish, it panics if the name is
+// already in use.
+func NewExpvarObserver(name string) *ExpvarObserver {
+	o := &ExpvarObserver{
+		active:          new(expvar.Map).Init(),
+		started:         new(expvar.Map).Init(),
+		stopped:         new(expvar.Map).Init(),
+		errors:          new(expvar.Map).Init(),
+		results:         new(expvar.Map).Init(),
+		resultSeconds:   new(expvar.Map).Init(),
+		callbacks:       new(expvar.Int),
+		queueDepth:      new(expvar.Int),
+		established:     new(expvar.Int),
+		lost:            new(expvar.Int),
+		nameStoreErrors: new(expvar.Int),
+		lastError:       new(expvar.String),
+	}
+	m := expvar.NewMap(name)
+	m.Set("ops_active", o.active)
+	m.Set("ops_started", o.started)
+	m.Set("ops_stopped", o.stopped)
+	m.Set("op_errors", o.errors)
+	m.Set("first_results", o.results)
+	m.Set("first_result_seconds", o.resultSeconds)
+	m.Set("callbacks", o.callbacks)
+	m.Set("callback_queue_depth", o.queueDepth)
+	m.Set("connections_established", o.established)
+	m.Set("connections_lost", o.lost)
+	m.Set("name_store_errors", o.nameStoreErrors)
+	m.Set("last_error", o.lastError)
+	return o
+}
+
+// OpStarted implements Observer.
+func (o *ExpvarObserver) OpStarted(kind string) {
+	o.started.Add(kind, 1)
+	o.active.Add(kind, 1)
+}
+
+// OpStopped implements Observer.
+func (o *ExpvarObserver) OpStopped(kind string) {
+	o.stopped.Add(kind, 1)
+	o.active.Add(kind, -1)
+}
+
+// OpError implements Observer.
+func (o *ExpvarObserver) OpError(kind string, err error) {
+	o.errors.Add(kind, 1)
+	o.active.Add(kind, -1)
+	o.lastError.Set(kind + ": " + err.Error())
+}
+
+// FirstResult implements Observer.
+func (o *ExpvarObserver) FirstResult(kind string, latency time.Duration) {
+	o.results.Add(kind, 1)
+	o.resultSeconds.AddFloat(kind, latency.Seconds())
+}
+
+// CallbackDelivered implements Observer.
+func (o *ExpvarObserver) CallbackDelivered() {
+	o.callbacks.Add(1)
+}
+
+// CallbackQueueDepth implements Observer.
+func (o *ExpvarObserver) CallbackQueueDepth(n int) {
+	o.queueDepth.Set(int64(n))
+}
+
+// ConnectionEstablished implements Observer.
+func (o *ExpvarObserver) ConnectionEstablished() {
+	o.established.Add(1)
+}
+
+// ConnectionLost implements Observer.
+func (o *ExpvarObserver) ConnectionLost(err error) {
+	o.lost.Add(1)
+	o.lastError.Set("connection: " + err.Error())
+}
+
+// NameStoreError implements Observer.
+func (o *ExpvarObserver) NameStoreError(err error) {
+	o.nameStoreErrors.Add(1)
+	o.lastError.Set("name store: " + err.Error())
+}
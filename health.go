@@ -0,0 +1,222 @@
+package dnssd
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Defaults used by HealthyRegistration.
+const (
+	DefaultHealthInterval      = 10 * time.Second
+	DefaultHealthPassThreshold = 2
+	DefaultHealthFailThreshold = 3
+)
+
+// HealthCheckFunc reports whether a service is healthy by returning nil.
+// The context is cancelled when the check interval elapses or the
+// HealthyRegistration is stopped.
+type HealthCheckFunc func(ctx context.Context) error
+
+// HealthCallbackFunc is called when a HealthyRegistration becomes healthy or unhealthy.
+// When becoming unhealthy err is the error returned by the failing check. If the
+// RegisterOp fails to start the callback receives its error with healthy false
+// and registration is retried after the next pass.
+type HealthCallbackFunc func(h *HealthyRegistration, err error, healthy bool)
+
+// HealthyRegistration advertises a service only while it passes a health check.
+//
+// The health check is run when the HealthyRegistration is started and on each
+// interval thereafter. The RegisterOp is started after PassThreshold consecutive
+// passes and stopped after FailThreshold consecutive failures. The RegisterOp
+// must not be started or stopped by other means while the HealthyRegistration
+// is active.
+type HealthyRegistration struct {
+	m         sync.Mutex
+	op        *RegisterOp
+	check     HealthCheckFunc
+	callback  HealthCallbackFunc
+	interval  time.Duration
+	passes    int
+	failures  int
+	started   bool
+	cancel    context.CancelFunc
+	healthy   bool
+	passCount int
+	failCount int
+}
+
+// NewHealthyRegistration creates a HealthyRegistration for op using the given health check.
+func NewHealthyRegistration(op *RegisterOp, check HealthCheckFunc, f HealthCallbackFunc) *HealthyRegistration {
+	return &HealthyRegistration{
+		op:       op,
+		check:    check,
+		callback: f,
+		interval: DefaultHealthInterval,
+		passes:   DefaultHealthPassThreshold,
+		failures: DefaultHealthFailThreshold,
+	}
+}
+
+// RegisterOp returns the RegisterOp that is started and stopped.
+func (h *HealthyRegistration) RegisterOp() *RegisterOp {
+	return h.op
+}
+
+// Interval returns the time between health checks.
+func (h *HealthyRegistration) Interval() time.Duration {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.interval
+}
+
+// SetInterval sets the time between health checks. It returns ErrBadInterval
+// if d isn't positive.
+func (h *HealthyRegistration) SetInterval(d time.Duration) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.started {
+		return ErrStarted
+	}
+	if d <= 0 {
+		return ErrBadInterval
+	}
+	h.interval = d
+	return nil
+}
+
+// PassThreshold returns the number of consecutive passes required to register the service.
+func (h *HealthyRegistration) PassThreshold() int {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.passes
+}
+
+// SetPassThreshold sets the number of consecutive passes required to register the service.
+func (h *HealthyRegistration) SetPassThreshold(n int) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.started {
+		return ErrStarted
+	}
+	h.passes = n
+	return nil
+}
+
+// FailThreshold returns the number of consecutive failures after which the service is deregistered.
+func (h *HealthyRegistration) FailThreshold() int {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.failures
+}
+
+// SetFailThreshold sets the number of consecutive failures after which the service is deregistered.
+func (h *HealthyRegistration) SetFailThreshold(n int) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.started {
+		return ErrStarted
+	}
+	h.failures = n
+	return nil
+}
+
+// Healthy indicates whether the service is currently considered healthy.
+func (h *HealthyRegistration) Healthy() bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.healthy
+}
+
+// Active indicates whether the HealthyRegistration is active.
+func (h *HealthyRegistration) Active() bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.started
+}
+
+// Start begins running health checks.
+func (h *HealthyRegistration) Start() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.started {
+		return ErrStarted
+	}
+	if h.check == nil || h.callback == nil {
+		return ErrMissingCallback
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h.started, h.cancel = true, cancel
+	h.healthy, h.passCount, h.failCount = false, 0, 0
+	go h.run(ctx)
+	return nil
+}
+
+// Stop stops running health checks and stops the RegisterOp.
+func (h *HealthyRegistration) Stop() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if !h.started {
+		return
+	}
+	h.started = false
+	h.cancel()
+	h.op.Stop()
+	h.healthy = false
+}
+
+func (h *HealthyRegistration) run(ctx context.Context) {
+	h.m.Lock()
+	interval := h.interval
+	h.m.Unlock()
+	if interval <= 0 {
+		interval = DefaultHealthInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := h.check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		h.record(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// record updates the health state with the result of a check, starting or
+// stopping the RegisterOp when a threshold is reached.
+func (h *HealthyRegistration) record(err error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if !h.started {
+		return
+	}
+	if err == nil {
+		h.failCount = 0
+		if h.passCount++; h.healthy || h.passCount < h.passes {
+			return
+		}
+		err = h.op.Start()
+		h.healthy = err == nil
+	} else {
+		h.passCount = 0
+		if h.failCount++; !h.healthy || h.failCount < h.failures {
+			return
+		}
+		h.healthy = false
+		h.op.Stop()
+	}
+	healthy := h.healthy
+	queueCallback(func() {
+		if h.Active() {
+			h.callback(h, err, healthy)
+		}
+	})
+}
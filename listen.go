@@ -0,0 +1,133 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultListenTimeout is how long Listen waits for the service to be registered.
+const DefaultListenTimeout = 30 * time.Second
+
+// Listen announces on the local network address and registers the listener
+// as a service with the given name and type until the listener is closed.
+//
+// The port registered is the one actually bound, so addr may specify port 0.
+// If addr specifies an IP address the service is only registered on the
+// interface that address belongs to, or InterfaceIndexLocalOnly for a loopback
+// address. Listen returns once the service has been registered, or with an
+// error if registration failed or took longer than DefaultListenTimeout.
+//
+// If registration fails after Listen has returned, such as when the daemon
+// restarts, the listener keeps accepting connections and Close returns the
+// error. Listen waits for a callback, so it must not be called from one.
+func Listen(network, addr, name, serviceType string, txt TXTRecord) (net.Listener, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultListenTimeout)
+	defer cancel()
+	return ListenContext(ctx, network, addr, name, serviceType, txt)
+}
+
+// ListenContext is like Listen but waits for the service to be registered
+// until ctx is done rather than for DefaultListenTimeout.
+func ListenContext(ctx context.Context, network, addr, name, serviceType string, txt TXTRecord) (net.Listener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tcpAddr, ok := ln.Addr().(*net.TCPAddr)
+	if !ok {
+		ln.Close()
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: ln.Addr(), Err: ErrListenerAddr}
+	}
+	l := &serviceListener{Listener: ln}
+	ch := make(chan error, 1)
+	registered := false
+	l.op = NewRegisterOp(name, serviceType, tcpAddr.Port, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		if !registered {
+			registered = true
+			ch <- err
+		} else if err != nil {
+			l.setErr(err)
+		}
+	})
+	if txt != nil {
+		err = l.op.SetTXT(txt)
+	}
+	if err == nil {
+		err = l.op.SetInterfaceIndex(listenInterfaceIndex(tcpAddr.IP))
+	}
+	if err == nil {
+		err = l.op.Start()
+	}
+	if err == nil {
+		select {
+		case err = <-ch:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			l.op.Stop()
+		}
+	}
+	if err != nil {
+		ln.Close()
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: tcpAddr, Err: err}
+	}
+	return l, nil
+}
+
+// listenInterfaceIndex returns the interface index a service listening on ip should be registered on.
+func listenInterfaceIndex(ip net.IP) int {
+	switch {
+	case ip == nil || ip.IsUnspecified():
+		return InterfaceIndexAny
+	case ip.IsLoopback():
+		return InterfaceIndexLocalOnly
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return InterfaceIndexAny
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return iface.Index
+			}
+		}
+	}
+	return InterfaceIndexAny
+}
+
+type serviceListener struct {
+	net.Listener
+	once sync.Once
+	op   *RegisterOp
+	m    sync.Mutex
+	err  error
+}
+
+func (l *serviceListener) setErr(err error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// Close deregisters the service and closes the listener. If registration
+// failed after Listen returned the error is returned.
+func (l *serviceListener) Close() error {
+	l.once.Do(l.op.Stop)
+	err := l.Listener.Close()
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.err != nil {
+		return &net.OpError{Op: "close", Net: l.Addr().Network(), Addr: l.Addr(), Err: l.err}
+	}
+	return err
+}
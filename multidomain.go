@@ -0,0 +1,200 @@
+package dnssd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DomainRegistration describes the state of a service's registration in a single domain.
+type DomainRegistration struct {
+	Domain string
+	// Name is the name the service is registered under, once registered.
+	Name       string
+	Registered bool
+	// Err is the error that ended registration in the domain, if any.
+	Err error
+}
+
+// MultiDomainCallbackFunc is called when registration in any domain changes,
+// with the status of every domain. If err is non-nil domain enumeration has
+// failed and the MultiDomainRegistration is no longer active.
+type MultiDomainCallbackFunc func(r *MultiDomainRegistration, err error, status []DomainRegistration)
+
+// MultiDomainRegistration registers a service in every registration domain
+// recommended by the daemon, adding and removing registrations as domains
+// come and go.
+type MultiDomainRegistration struct {
+	m        sync.Mutex
+	template *RegisterOp
+	callback MultiDomainCallbackFunc
+	enum     *DomainEnumOp
+	domains  map[string]*domainRegistration
+}
+
+type domainRegistration struct {
+	op         *RegisterOp
+	interfaces map[int]bool // interfaces the domain has been enumerated on
+	status     DomainRegistration
+}
+
+// NewMultiDomainRegistration creates a MultiDomainRegistration that registers
+// services configured as op is, other than its domain and callback. op itself
+// is not started and changes made to it after Start have no effect.
+func NewMultiDomainRegistration(op *RegisterOp, f MultiDomainCallbackFunc) *MultiDomainRegistration {
+	return &MultiDomainRegistration{template: op, callback: f}
+}
+
+// Active indicates whether the MultiDomainRegistration is active.
+func (r *MultiDomainRegistration) Active() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.enum != nil
+}
+
+// Status returns the status of registration in each domain, sorted by domain.
+func (r *MultiDomainRegistration) Status() []DomainRegistration {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.status()
+}
+
+func (r *MultiDomainRegistration) status() []DomainRegistration {
+	s := make([]DomainRegistration, 0, len(r.domains))
+	for _, d := range r.domains {
+		s = append(s, d.status)
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i].Domain < s[j].Domain })
+	return s
+}
+
+// Start begins enumerating registration domains.
+func (r *MultiDomainRegistration) Start() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.enum != nil {
+		return ErrStarted
+	}
+	if r.callback == nil {
+		return ErrMissingCallback
+	}
+	op := NewDomainEnumOp(r.enumCallback)
+	op.SetRegistrationDomains(true)
+	op.SetInterfaceIndex(r.template.InterfaceIndex())
+	r.domains = make(map[string]*domainRegistration)
+	r.enum = op
+	if err := op.Start(); err != nil {
+		r.enum = nil
+		return err
+	}
+	return nil
+}
+
+// Stop stops domain enumeration and all registrations.
+func (r *MultiDomainRegistration) Stop() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.stop()
+}
+
+func (r *MultiDomainRegistration) stop() {
+	if r.enum == nil {
+		return
+	}
+	r.enum.Stop()
+	r.enum = nil
+	for _, d := range r.domains {
+		d.op.Stop()
+	}
+	r.domains = nil
+}
+
+// emit invokes the callback. The mutex must be held and is released for the
+// duration of the callback.
+func (r *MultiDomainRegistration) emit(err error) {
+	status := r.status()
+	r.m.Unlock()
+	defer r.m.Lock()
+	r.callback(r, err, status)
+}
+
+func (r *MultiDomainRegistration) enumCallback(op *DomainEnumOp, err error, add bool, interfaceIndex int, domain string, isDefault bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if op != r.enum {
+		return
+	}
+	if err != nil {
+		r.stop()
+		r.emit(err)
+		return
+	}
+	key := strings.ToLower(strings.TrimSuffix(domain, "."))
+	d := r.domains[key]
+	if !add {
+		if d == nil {
+			return
+		}
+		delete(d.interfaces, interfaceIndex)
+		if len(d.interfaces) > 0 {
+			return
+		}
+		d.op.Stop()
+		delete(r.domains, key)
+		r.emit(nil)
+		return
+	}
+	if d != nil {
+		d.interfaces[interfaceIndex] = true
+		return
+	}
+	d = &domainRegistration{
+		interfaces: map[int]bool{interfaceIndex: true},
+		status:     DomainRegistration{Domain: domain},
+	}
+	d.op = r.template.copyFor(domain, func(op *RegisterOp, err error, add bool, name, serviceType, domain string) {
+		r.registerCallback(d, op, err, add, name)
+	})
+	r.domains[key] = d
+	if err := d.op.Start(); err != nil {
+		d.status.Err = err
+	}
+	r.emit(nil)
+}
+
+func (r *MultiDomainRegistration) registerCallback(d *domainRegistration, op *RegisterOp, err error, add bool, name string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	key := strings.ToLower(strings.TrimSuffix(d.status.Domain, "."))
+	if r.domains[key] != d {
+		return
+	}
+	d.status.Registered = err == nil && add
+	d.status.Err = err
+	if d.status.Registered {
+		d.status.Name = name
+	}
+	r.emit(nil)
+}
+
+// copyFor returns a RegisterOp configured as o is but for the given domain and callback.
+func (o *RegisterOp) copyFor(domain string, f RegisterCallbackFunc) *RegisterOp {
+	o.m.Lock()
+	defer o.m.Unlock()
+	c := &RegisterOp{
+		name:      o.name,
+		stype:     o.stype,
+		subtypes:  append([]string(nil), o.subtypes...),
+		domain:    domain,
+		host:      o.host,
+		port:      o.port,
+		callback:  f,
+		nameStore: o.nameStore,
+	}
+	c.txt.l, c.txt.r = o.txt.l, o.txt.r.Copy()
+	c.conflict.resolver = o.conflict.resolver
+	c.interfaceIndex = o.interfaceIndex
+	c.flags = o.flags &^ _FlagsShareConnection
+	c.skipValidation = o.skipValidation
+	return c
+}
@@ -0,0 +1,94 @@
+package dnssd
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxInstanceName is the maximum length in bytes of a service instance name.
+const maxInstanceName = 63
+
+// NormalizeName returns s in Unicode Normalization Form C. Instance names
+// are free-form UTF-8 and macOS tends to produce the decomposed form of
+// characters that other systems precompose, so names are normalized before
+// they're registered or compared.
+func NormalizeName(s string) string {
+	return norm.NFC.String(s)
+}
+
+// NormalizeInstanceName returns NormalizeName(s) truncated to the 63 byte
+// limit on instance names. Truncation never splits a character or separates
+// it from any combining marks that follow it.
+func NormalizeInstanceName(s string) string {
+	return truncateName(NormalizeName(s), maxInstanceName)
+}
+
+// truncateName returns the longest prefix of s of at most n bytes that ends
+// on a rune and normalization boundary.
+func truncateName(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	i := n
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	for i > 0 && !norm.NFC.PropertiesString(s[i:]).BoundaryBefore() {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	return s[:i]
+}
+
+// domainToASCII converts the unicast domain at the end of the presentation
+// format name s to its IDNA ASCII form. Labels up to and including the last
+// that begins with an underscore, such as the instance name and service type
+// of a service's full name, are left alone, as are names in ".local" since
+// Multicast DNS uses UTF-8 directly.
+func domainToASCII(s string) (string, error) {
+	start := 0
+	for i, label := 0, 0; i <= len(s); i++ {
+		if i < len(s) && s[i] == '\\' {
+			i++
+			continue
+		}
+		if i == len(s) || s[i] == '.' {
+			if i > label && s[label] == '_' {
+				start = i
+				if i < len(s) {
+					start++
+				}
+			}
+			label = i + 1
+		}
+	}
+	prefix, domain := s[:start], s[start:]
+	if isASCII(domain) {
+		return s, nil
+	}
+	fqdn := strings.HasSuffix(domain, ".")
+	domain = strings.TrimSuffix(domain, ".")
+	if l := strings.ToLower(domain); l == "local" || strings.HasSuffix(l, ".local") {
+		return s, nil
+	}
+	domain, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	if fqdn {
+		domain += "."
+	}
+	return prefix + domain, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
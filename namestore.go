@@ -0,0 +1,111 @@
+package dnssd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// NameStore persists the names services are registered under so that a
+// service that has been renamed keeps its new name on subsequent runs.
+//
+// Names are stored per domain, as a service renamed because of a conflict in
+// one domain may have kept its name in others. The domain is the one set on
+// the RegisterOp, which is empty when registering in the default domains.
+type NameStore interface {
+	// LookupName returns the name stored for the service with the given
+	// name, type and domain, or an empty string if there is none.
+	LookupName(name, serviceType, domain string) (string, error)
+	// StoreName stores the name a service with the given name, type and
+	// domain was registered under.
+	StoreName(name, serviceType, domain, registered string) error
+}
+
+// FileNameStore is a NameStore backed by a JSON file.
+//
+// The file holds an object mapping each domain to an object mapping each
+// service type to an object mapping names to the names they were registered
+// under. It's created when a name is first stored and replaced atomically
+// when updated.
+type FileNameStore struct {
+	m    sync.Mutex
+	path string
+}
+
+// NewFileNameStore returns a FileNameStore using the file at path.
+func NewFileNameStore(path string) *FileNameStore {
+	return &FileNameStore{path: path}
+}
+
+type storedNames map[string]map[string]map[string]string
+
+func (s *FileNameStore) load() (storedNames, error) {
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return make(storedNames), nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make(storedNames)
+	if err := json.Unmarshal(b, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// storeDomain returns the key a domain is stored under, which matches
+// regardless of case or a trailing dot.
+func storeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// LookupName implements NameStore.
+func (s *FileNameStore) LookupName(name, serviceType, domain string) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	names, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	return names[storeDomain(domain)][strings.ToLower(serviceType)][name], nil
+}
+
+// StoreName implements NameStore.
+func (s *FileNameStore) StoreName(name, serviceType, domain, registered string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	names, err := s.load()
+	if err != nil {
+		return err
+	}
+	domain, serviceType = storeDomain(domain), strings.ToLower(serviceType)
+	if names[domain] == nil {
+		names[domain] = make(map[string]map[string]string)
+	}
+	if names[domain][serviceType] == nil {
+		names[domain][serviceType] = make(map[string]string)
+	}
+	names[domain][serviceType][name] = registered
+	b, err := json.MarshalIndent(names, "", "\t")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
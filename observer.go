@@ -0,0 +1,76 @@
+package dnssd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of op reported to an Observer.
+const (
+	OpKindRegister    = "register"
+	OpKindBrowse      = "browse"
+	OpKindResolve     = "resolve"
+	OpKindQuery       = "query"
+	OpKindEnumDomains = "enumdomains"
+)
+
+// Observer is notified of the package's activity. Its methods may be called
+// concurrently and while internal locks are held, so they must return quickly
+// and must not start or stop ops.
+type Observer interface {
+	// OpStarted is called when an op of the given kind is started.
+	OpStarted(kind string)
+	// OpStopped is called when an op is stopped.
+	OpStopped(kind string)
+	// OpError is called when an op stops because of an error.
+	OpError(kind string, err error)
+	// FirstResult is called when an op receives its first result, with the
+	// time since it was started.
+	FirstResult(kind string, latency time.Duration)
+	// CallbackDelivered is called after a callback has returned.
+	CallbackDelivered()
+	// CallbackQueueDepth is called with the number of callbacks waiting to
+	// be delivered whenever it changes.
+	CallbackQueueDepth(n int)
+	// ConnectionEstablished is called when the connection shared by ops is
+	// established.
+	ConnectionEstablished()
+	// ConnectionLost is called when the shared connection fails or can't be
+	// established.
+	ConnectionLost(err error)
+	// NameStoreError is called when a RegisterOp's NameStore fails to
+	// store the name a service was registered under.
+	NameStoreError(err error)
+}
+
+type observerHolder struct{ o Observer }
+
+var observerValue atomic.Value
+
+// SetObserver sets the Observer notified of the package's activity. Passing
+// nil removes it.
+func SetObserver(o Observer) {
+	observerValue.Store(observerHolder{o})
+}
+
+func observer() Observer {
+	h, _ := observerValue.Load().(observerHolder)
+	return h.o
+}
+
+// opKind returns the kind of op p reported to an Observer.
+func opKind(p pollable) string {
+	switch p.(type) {
+	case *RegisterOp:
+		return OpKindRegister
+	case *BrowseOp:
+		return OpKindBrowse
+	case *ResolveOp:
+		return OpKindResolve
+	case *QueryOp:
+		return OpKindQuery
+	case *DomainEnumOp:
+		return OpKindEnumDomains
+	}
+	return "unknown"
+}
@@ -0,0 +1,136 @@
+package dnssd
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpInfo describes an active op.
+type OpInfo struct {
+	// Kind is one of the OpKind constants.
+	Kind           string `json:"kind"`
+	InterfaceIndex int    `json:"interfaceIndex"`
+	// Params holds the op's parameters, such as "name", "type" and "domain".
+	Params map[string]string `json:"params"`
+	// Started is when the op was last started.
+	Started time.Time `json:"started"`
+	// Shared indicates whether the op uses the connection shared by ops
+	// rather than one of its own.
+	Shared bool `json:"shared"`
+	// Results is the number of results the daemon has delivered for the op.
+	Results int `json:"results"`
+}
+
+// ActiveOps returns information about the active ops, ordered by start time.
+func ActiveOps() []OpInfo {
+	ops := pollServer.ops()
+	infos := make([]OpInfo, 0, len(ops))
+	for _, op := range ops {
+		infos = append(infos, OpInfo{
+			Kind:           opKind(op.p),
+			InterfaceIndex: opInterfaceIndex(op.p),
+			Params:         opParams(op.p),
+			Started:        op.started,
+			Shared:         op.fd <= 0,
+			Results:        op.results,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
+
+func opInterfaceIndex(p pollable) int {
+	if o, ok := p.(interface{ InterfaceIndex() int }); ok {
+		return o.InterfaceIndex()
+	}
+	return 0
+}
+
+// opParams returns the parameters of an op. It must not be called with the
+// poll server's locks held as it takes the op's lock.
+func opParams(p pollable) map[string]string {
+	params := make(map[string]string)
+	set := func(k, v string) {
+		if v != "" {
+			params[k] = v
+		}
+	}
+	switch o := p.(type) {
+	case *RegisterOp:
+		set("name", o.Name())
+		set("type", o.Type())
+		set("subtypes", strings.Join(o.Subtypes(), ","))
+		set("domain", o.Domain())
+		set("host", o.Host())
+		set("port", strconv.Itoa(o.Port()))
+	case *BrowseOp:
+		set("type", o.Type())
+		set("subtype", o.Subtype())
+		set("domain", o.Domain())
+	case *ResolveOp:
+		set("name", o.Name())
+		set("type", o.Type())
+		set("domain", o.Domain())
+	case *QueryOp:
+		set("name", o.Name())
+		set("type", strconv.Itoa(int(o.Type())))
+		set("class", strconv.Itoa(int(o.Class())))
+	case *DomainEnumOp:
+		set("registrationDomains", strconv.FormatBool(o.RegistrationDomains()))
+	}
+	return params
+}
+
+// ActiveOpsHandler returns an http.Handler that renders ActiveOps as an HTML
+// table, or as JSON if the request has a "format=json" query parameter or
+// accepts "application/json".
+func ActiveOpsHandler() http.Handler {
+	return http.HandlerFunc(serveActiveOps)
+}
+
+var activeOpsTemplate = template.Must(template.New("ops").Parse(`<!DOCTYPE html>
+<html>
+<head><title>dnssd ops</title></head>
+<body>
+<h1>{{len .}} active ops</h1>
+<table border="1">
+<tr><th>Kind</th><th>Interface</th><th>Parameters</th><th>Started</th><th>Connection</th><th>Results</th></tr>
+{{range .}}<tr>
+<td>{{.Kind}}</td>
+<td>{{.InterfaceIndex}}</td>
+<td>{{range $k, $v := .Params}}{{$k}}={{$v}}<br>{{end}}</td>
+<td>{{.Started.Format "2006-01-02T15:04:05.000Z07:00"}}</td>
+<td>{{if .Shared}}shared{{else}}dedicated{{end}}</td>
+<td>{{.Results}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveActiveOps renders the active ops into a buffer first so that a
+// failure can be reported with a 500 rather than a truncated response.
+func serveActiveOps(w http.ResponseWriter, r *http.Request) {
+	ops := ActiveOps()
+	var b bytes.Buffer
+	var err error
+	contentType := "text/html; charset=utf-8"
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		contentType = "application/json"
+		err = json.NewEncoder(&b).Encode(ops)
+	} else {
+		err = activeOpsTemplate.Execute(&b, ops)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	b.WriteTo(w)
+}
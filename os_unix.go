@@ -115,6 +115,37 @@ static int32_t dnssdQuery(
     return DNSServiceQueryRecord(sdRef, flags, ifIndex, name, rrtype, rrclass, callback, context);
 }
 
+extern void enumDomainsCallbackWrapper(
+    void                  *sdRef,
+    uint32_t              flags,
+    uint32_t              ifIndex,
+    int32_t               errorCode,
+    void                  *replyDomain,
+    void                  *context
+    );
+
+static int32_t dnssdEnumerateDomains(
+    void                  *sdRef,
+    DNSServiceFlags       flags,
+    uint32_t              ifIndex,
+    void                  *context
+    ) {
+    DNSServiceDomainEnumReply callback = (DNSServiceDomainEnumReply) enumDomainsCallbackWrapper;
+    return DNSServiceEnumerateDomains(sdRef, flags, ifIndex, callback, context);
+}
+
+static int32_t dnssdReconfirmRecord(
+    DNSServiceFlags       flags,
+    uint32_t              ifIndex,
+    const char            *fullname,
+    uint16_t              rrtype,
+    uint16_t              rrclass,
+    uint16_t              rdlen,
+    const void            *rdata
+    ) {
+    return DNSServiceReconfirmRecord(flags, ifIndex, fullname, rrtype, rrclass, rdlen, rdata);
+}
+
 static uint16_t dnssdNtohs(uint16_t n) {
 	return ntohs(n);
 }
@@ -223,6 +254,33 @@ func queryCallbackWrapper(sdRef unsafe.Pointer, flags, ifIndex uint32, err int32
 	dnssdQueryCallback(sdRef, flags, ifIndex, err, f, rrtype, rrclass, rdlen, rdata, ttl, ctx)
 }
 
+func enumDomainsStart(ref *uintptr, flags, ifIndex uint32, ctx unsafe.Pointer) error {
+	cref := unsafe.Pointer(ref)
+	cflags := C.DNSServiceFlags(flags)
+	cifIndex := C.uint32_t(ifIndex)
+	return getError(int32(C.dnssdEnumerateDomains(cref, cflags, cifIndex, ctx)))
+}
+
+//export enumDomainsCallbackWrapper
+func enumDomainsCallbackWrapper(sdRef unsafe.Pointer, flags, ifIndex uint32, err int32, domain, ctx unsafe.Pointer) {
+	dnssdEnumDomainsCallback(sdRef, flags, ifIndex, err, domain, ctx)
+}
+
+func reconfirmRecord(flags, ifIndex uint32, fullname string, rrtype, rrclass uint16, rdata []byte) error {
+	cflags := C.DNSServiceFlags(flags)
+	cifIndex := C.uint32_t(ifIndex)
+	cname := C.CString(fullname)
+	defer C.free(unsafe.Pointer(cname))
+	crrtype, crrclass := C.uint16_t(rrtype), C.uint16_t(rrclass)
+	rdlen := C.uint16_t(len(rdata))
+	rdataPtr := unsafe.Pointer(nil)
+	if rdlen > 0 {
+		rdataPtr = unsafe.Pointer(&rdata[0])
+	}
+	e := C.dnssdReconfirmRecord(cflags, cifIndex, cname, crrtype, crrclass, rdlen, rdataPtr)
+	return getError(int32(e))
+}
+
 func refSockFd(ref *uintptr) int {
 	return int(C.DNSServiceRefSockFD(*(*C.DNSServiceRef)(unsafe.Pointer(ref))))
 }
@@ -299,6 +357,7 @@ func pollLoop(s *pollServerState) {
 				// callback invoked. can call them anyway since we only pass on the first error.
 				s.shared.ref = 0
 				s.shared.fd = 0
+				s.connectionLost(e)
 				for i := range sharedPollables {
 					sharedPollables[i].ref = 0
 					sharedPollables[i].p.handleError(e)
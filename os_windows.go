@@ -198,6 +198,52 @@ func queryCallbackWrapper(sdRef unsafe.Pointer, flags, ifIndex uint, err int, fu
 	return 0
 }
 
+func enumDomainsStart(ref *uintptr, flags, ifIndex uint32, ctx unsafe.Pointer) error {
+	proc, err := getProc("dnssd.dll", "DNSServiceEnumerateDomains")
+	if err != nil {
+		return err
+	}
+	r, _, _ := proc.Call(
+		(uintptr)(unsafe.Pointer(ref)),
+		uintptr(flags),
+		uintptr(ifIndex),
+		syscall.NewCallback(enumDomainsCallbackWrapper),
+		(uintptr)(ctx),
+	)
+	return getError(int32(r))
+}
+
+func enumDomainsCallbackWrapper(sdRef unsafe.Pointer, flags, ifIndex uint, err int, domain, ctx unsafe.Pointer) uintptr {
+	dnssdEnumDomainsCallback(sdRef, uint32(flags), uint32(ifIndex), int32(err), domain, ctx)
+	return 0
+}
+
+func reconfirmRecord(flags, ifIndex uint32, fullname string, rrtype, rrclass uint16, rdata []byte) error {
+	proc, err := getProc("dnssd.dll", "DNSServiceReconfirmRecord")
+	if err != nil {
+		return err
+	}
+	bname, err := syscall.BytePtrFromString(fullname)
+	if err != nil {
+		return err
+	}
+	rdlen := uintptr(len(rdata))
+	rdataPtr := unsafe.Pointer(nil)
+	if rdlen > 0 {
+		rdataPtr = unsafe.Pointer(&rdata[0])
+	}
+	r, _, _ := proc.Call(
+		uintptr(flags),
+		uintptr(ifIndex),
+		(uintptr)(unsafe.Pointer(bname)),
+		uintptr(rrtype),
+		uintptr(rrclass),
+		rdlen,
+		(uintptr)(rdataPtr),
+	)
+	return getError(int32(r))
+}
+
 func refSockFd(ref *uintptr) int {
 	proc := mustGetProc("dnssd.dll", "DNSServiceRefSockFD")
 	fd, _, _ := proc.Call(*ref)
@@ -271,6 +317,7 @@ func pollLoop(s *pollServerState) {
 				// callback invoked. can call them anyway since we only pass on the first error.
 				s.shared.ref = 0
 				s.shared.fd = 0
+				s.connectionLost(err)
 				for i := range sharedPollables {
 					sharedPollables[i].ref = 0
 					sharedPollables[i].p.handleError(err)
@@ -1,6 +1,9 @@
 package dnssd
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 type pollable interface {
 	init(uintptr) (uintptr, error)
@@ -8,9 +11,12 @@ type pollable interface {
 }
 
 type pollServerOp struct {
-	p   pollable
-	ref uintptr
-	fd  int
+	p       pollable
+	ref     uintptr
+	fd      int
+	started time.Time
+	// results is the number of results the daemon has delivered.
+	results int
 }
 
 var pollServer pollServerState
@@ -31,6 +37,16 @@ type pollServerState struct {
 }
 
 func (s *pollServerState) startOp(p pollable) error {
+	return s.addOp(p, true)
+}
+
+// restartOp starts an op again after it was removed with removePollOp,
+// without reporting it to the Observer as a newly started op.
+func (s *pollServerState) restartOp(p pollable) error {
+	return s.addOp(p, false)
+}
+
+func (s *pollServerState) addOp(p pollable, notify bool) error {
 	s.m.external.Lock()
 	if s.pollables == nil {
 		s.pollables = make(map[pollable]*pollServerOp)
@@ -54,7 +70,10 @@ func (s *pollServerState) startOp(p pollable) error {
 	if s.shared.ref == 0 {
 		fd = refSockFd(&ref)
 	}
-	s.addPollOp(&pollServerOp{p: p, ref: ref, fd: fd})
+	s.addPollOp(&pollServerOp{p: p, ref: ref, fd: fd, started: time.Now()})
+	if o := observer(); o != nil && notify {
+		o.OpStarted(opKind(p))
+	}
 	return nil
 }
 
@@ -63,6 +82,9 @@ func (s *pollServerState) stopOp(p pollable) error {
 	defer s.m.external.Unlock()
 	s.stopPoll()
 	s.m.internal.Lock()
+	if _, present := s.pollables[p]; present {
+		s.opStopped(p)
+	}
 	s.removePollOp(p)
 	s.m.internal.Unlock()
 	s.startPoll()
@@ -94,6 +116,68 @@ func (s *pollServerState) removePollOp(p pollable) {
 	}
 }
 
+// failOp removes an op that has stopped because of an error.
+func (s *pollServerState) failOp(p pollable, err error) {
+	if _, present := s.pollables[p]; present {
+		s.opError(p, err)
+	}
+	s.removePollOp(p)
+}
+
+// opStopped reports an op that has been stopped. stopOp calls it; ops
+// removed with removePollOp call it directly if they're stopped before
+// being restarted.
+func (s *pollServerState) opStopped(p pollable) {
+	if o := observer(); o != nil {
+		o.OpStopped(opKind(p))
+	}
+}
+
+// opError reports an op that has stopped because of an error. failOp calls it;
+// ops removed with removePollOp call it directly once they've given up.
+func (s *pollServerState) opError(p pollable, err error) {
+	if o := observer(); o != nil {
+		o.OpError(opKind(p), err)
+	}
+}
+
+// countResult counts a result delivered to an op, reporting the latency to
+// its first. It's called from the daemon's callbacks, which run while the
+// poll loop holds the internal lock.
+func (s *pollServerState) countResult(p pollable) {
+	op, present := s.pollables[p]
+	if !present {
+		return
+	}
+	if op.results++; op.results == 1 {
+		if o := observer(); o != nil {
+			o.FirstResult(opKind(p), time.Since(op.started))
+		}
+	}
+}
+
+// ops returns a copy of the ops being polled.
+func (s *pollServerState) ops() []pollServerOp {
+	s.m.external.Lock()
+	defer s.m.external.Unlock()
+	s.stopPoll()
+	s.m.internal.Lock()
+	ops := make([]pollServerOp, 0, len(s.pollables))
+	for _, op := range s.pollables {
+		ops = append(ops, *op)
+	}
+	s.m.internal.Unlock()
+	s.startPoll()
+	return ops
+}
+
+// connectionLost reports the failure of the shared connection.
+func (s *pollServerState) connectionLost(err error) {
+	if o := observer(); o != nil {
+		o.ConnectionLost(err)
+	}
+}
+
 func (s *pollServerState) addPollOp(p *pollServerOp) {
 	s.pollables[p.p] = p
 	s.sharedPollableElements, s.uniquePollableElements = nil, nil
@@ -103,13 +187,16 @@ func (s *pollServerState) addPollOp(p *pollServerOp) {
 func (s *pollServerState) establishSharedConnection() {
 	if len(s.pollables) == 0 && s.shared.ref == 0 {
 		if err := createConnection(&s.shared.ref); err != nil {
-			_ = err // TODO: do something with err?
 			s.shared.ref = 0
+			s.connectionLost(err)
 		} else {
 			s.shared.fd = refSockFd(&s.shared.ref)
 			if s.shared.fd < 0 {
 				panic("bad fd")
 			}
+			if o := observer(); o != nil {
+				o.ConnectionEstablished()
+			}
 		}
 	}
 }
@@ -1,26 +1,67 @@
 package dnssd
 
-import "unsafe"
+import (
+	"unsafe"
+
+	"github.com/andrewtj/dnssd/rr"
+)
 
 // QueryCallbackFunc is called when an error occurs or a record is added or removed.
 // Results may be cached for ttl seconds. After ttl seconds the result should be discarded.
 // Alternatively the operation may be left running in which case the result can be considered valid
 // until a callback indicates otherwise.
+// If ReturnIntermediates is set, err may be ErrNoSuchRecord to indicate a negative answer, in which
+// case the operation remains active.
 type QueryCallbackFunc func(op *QueryOp, err error, add bool, interfaceIndex int, fullname string, rrtype, rrclass uint16, rdata []byte, ttl uint32)
 
+// QueryEvent describes a record being added or removed.
+type QueryEvent struct {
+	Add            bool
+	InterfaceIndex int
+	Name           string
+	Type           uint16
+	Class          uint16
+	// RData is the decoded record data. It's nil for a negative answer. If
+	// the data couldn't be decoded as its type it's an *rr.Unknown.
+	RData      rr.RData
+	TTL        uint32
+	MoreComing bool
+}
+
+// QueryEventCallbackFunc is called when an error occurs or a record is added or removed.
+// It's passed the same information as QueryCallbackFunc with the record data decoded.
+type QueryEventCallbackFunc func(op *QueryOp, err error, e QueryEvent)
+
 // QueryOp represents a query for a specific name, class and type.
 type QueryOp struct {
 	baseOp
 	name            string
 	rrtype, rrclass uint16
 	callback        QueryCallbackFunc
+	ecb             QueryEventCallbackFunc
+	rcb             func(op *QueryOp, err error, r queryResult)
+}
+
+// queryResult holds the arguments of a query callback along with
+// whether more results are immediately available.
+type queryResult struct {
+	add             bool
+	interfaceIndex  int
+	fullname        string
+	rrtype, rrclass uint16
+	rdata           []byte
+	ttl             uint32
+	moreComing      bool
 }
 
 // NewQueryOp creates a new QueryOp with the associated parameters set.
 func NewQueryOp(interfaceIndex int, name string, rrtype, rrclass uint16, f QueryCallbackFunc) *QueryOp {
 	op := &QueryOp{}
 	op.SetInterfaceIndex(interfaceIndex)
-	op.SetName(name)
+	if op.SetName(name) != nil {
+		// Keep the name as given so that Start reports it.
+		op.name = name
+	}
 	op.SetType(rrtype)
 	op.SetClass(rrclass)
 	op.SetCallback(f)
@@ -40,14 +81,20 @@ func (o *QueryOp) Name() string {
 	return o.name
 }
 
-// SetName sets the domain name for the operation.
+// SetName sets the domain name for the operation. An internationalized
+// unicast domain name is converted to its IDNA ASCII form. If it can't be,
+// an error is returned and the op's name is left unchanged.
 func (o *QueryOp) SetName(n string) error {
 	o.m.Lock()
 	defer o.m.Unlock()
 	if o.started {
 		return ErrStarted
 	}
-	o.name = n
+	name, err := domainToASCII(n)
+	if err != nil {
+		return &ValidationError{FieldName, n, ErrBadDomain}
+	}
+	o.name = name
 	return nil
 }
 
@@ -98,6 +145,122 @@ func (o *QueryOp) SetCallback(f QueryCallbackFunc) error {
 	return nil
 }
 
+// SetEventCallback sets a function to call with decoded records. It may be
+// set instead of, or as well as, the function set by SetCallback.
+func (o *QueryOp) SetEventCallback(f QueryEventCallbackFunc) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.ecb = f
+	return nil
+}
+
+// ForceMulticast indicates whether the query will be performed using multicast only.
+func (o *QueryOp) ForceMulticast() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsForceMulticast != 0
+}
+
+// SetForceMulticast sets whether the query will be performed using multicast only.
+// By default names outside of ".local" are queried using unicast DNS. If set to true
+// the query will be sent via multicast DNS regardless of the name's domain.
+func (o *QueryOp) SetForceMulticast(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsForceMulticast, e)
+	return nil
+}
+
+// LongLivedQuery indicates whether a long-lived unicast query will be created.
+func (o *QueryOp) LongLivedQuery() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsLongLivedQuery != 0
+}
+
+// SetLongLivedQuery sets whether a long-lived unicast query will be created.
+// If set to true and the server supports it, changes to records in a unicast domain
+// are pushed to the client rather than requiring the client to poll.
+func (o *QueryOp) SetLongLivedQuery(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsLongLivedQuery, e)
+	return nil
+}
+
+// ReturnIntermediates indicates whether intermediate results will be passed to the callback.
+func (o *QueryOp) ReturnIntermediates() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsReturnIntermediates != 0
+}
+
+// SetReturnIntermediates sets whether intermediate results will be passed to the callback.
+// If set to true CNAME records encountered while following a name will be
+// passed to the callback, as will negative answers. A negative answer is
+// indicated by ErrNoSuchRecord being passed to the callback with empty rdata.
+// Unlike other errors, ErrNoSuchRecord does not stop the operation.
+func (o *QueryOp) SetReturnIntermediates(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsReturnIntermediates, e)
+	return nil
+}
+
+// Timeout indicates whether the daemon will stop the query after a period of time.
+func (o *QueryOp) Timeout() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsTimeout != 0
+}
+
+// SetTimeout sets whether the daemon will stop the query after a period of time.
+// If set to true the query is stopped after a system determined number of
+// seconds regardless of whether any results were returned. When this happens
+// the callback is invoked with ErrTimeout and the operation is no longer active.
+func (o *QueryOp) SetTimeout(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsTimeout, e)
+	return nil
+}
+
+// SuppressUnusable indicates whether unusable address records will be suppressed.
+func (o *QueryOp) SuppressUnusable() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsSuppressUnusable != 0
+}
+
+// SetSuppressUnusable sets whether unusable address records will be suppressed.
+// If set to true AAAA records will not be returned if the machine has no
+// routable IPv6 address, and likewise A records for IPv4. Queries for
+// other record types are unaffected.
+func (o *QueryOp) SetSuppressUnusable(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsSuppressUnusable, e)
+	return nil
+}
+
 // Start begins the query operation.
 func (o *QueryOp) Start() error {
 	o.m.Lock()
@@ -105,9 +268,14 @@ func (o *QueryOp) Start() error {
 	if o.started {
 		return ErrStarted
 	}
-	if o.callback == nil {
+	if o.callback == nil && o.ecb == nil && o.rcb == nil {
 		return ErrMissingCallback
 	}
+	if !o.skipValidation {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
 	err := pollServer.startOp(o)
 	o.started = err == nil || err == ErrStarted
 	return err
@@ -130,6 +298,7 @@ func (o *QueryOp) Stop() {
 		return
 	}
 	o.started = false
+	pollServer.stopOp(o)
 }
 
 func (o *QueryOp) handleError(e error) {
@@ -137,24 +306,76 @@ func (o *QueryOp) handleError(e error) {
 		return
 	}
 	o.started = false
-	pollServer.removePollOp(o)
-	queueCallback(func() { o.callback(o, e, false, 0, "", 0, 0, nil, 0) })
+	pollServer.failOp(o, e)
+	o.queueCallback(e, queryResult{})
+}
+
+func (o *QueryOp) queueCallback(e error, r queryResult) {
+	o.deliver(func() {
+		if o.callback != nil {
+			o.callback(o, e, r.add, r.interfaceIndex, r.fullname, r.rrtype, r.rrclass, r.rdata, r.ttl)
+		}
+		if o.ecb != nil {
+			o.ecb(o, e, r.event())
+		}
+		if o.rcb != nil {
+			o.rcb(o, e, r)
+		}
+	})
+}
+
+func (r queryResult) event() QueryEvent {
+	e := QueryEvent{
+		Add:            r.add,
+		InterfaceIndex: r.interfaceIndex,
+		Name:           r.fullname,
+		Type:           r.rrtype,
+		Class:          r.rrclass,
+		TTL:            r.ttl,
+		MoreComing:     r.moreComing,
+	}
+	if r.rdata != nil {
+		rd, err := rr.Decode(r.rrtype, r.rdata)
+		if err != nil {
+			rd = &rr.Unknown{RRType: r.rrtype, Data: r.rdata}
+		}
+		e.RData = rd
+	}
+	return e
 }
 
 func dnssdQueryCallback(sdRef unsafe.Pointer, flags, interfaceIndex uint32, err int32, fullname unsafe.Pointer, rrtype, rrclass, rdlen uint16, rdataptr unsafe.Pointer, ttl uint32, ctx unsafe.Pointer) {
 	o := (*QueryOp)(ctx)
-	if e := getError(err); e != nil {
+	e := getError(err)
+	// With ReturnIntermediates set ErrNoSuchRecord is a negative answer
+	// rather than a failure of the operation.
+	if e != nil && (e != ErrNoSuchRecord || o.flags&_FlagsReturnIntermediates == 0) {
 		o.handleError(e)
-	} else {
-		a := flags&_FlagsAdd != 0
-		i := int(interfaceIndex)
-		f := cStringToString(fullname)
-		var rdata []byte
-		if rdlen > 0 && rdataptr != nil {
-			s := (*[65535]byte)(rdataptr)[:rdlen]
-			rdata = make([]byte, rdlen)
-			copy(rdata, s)
-		}
-		queueCallback(func() { o.callback(o, e, a, i, f, rrtype, rrclass, rdata, ttl) })
+		return
+	}
+	pollServer.countResult(o)
+	r := queryResult{
+		add:            flags&_FlagsAdd != 0,
+		interfaceIndex: interfaceIndexGo(interfaceIndex),
+		fullname:       cStringToString(fullname),
+		rrtype:         rrtype,
+		rrclass:        rrclass,
+		ttl:            ttl,
+		moreComing:     flags&_FlagsMoreComing != 0,
+	}
+	if e == nil && rdlen > 0 && rdataptr != nil {
+		s := (*[65535]byte)(rdataptr)[:rdlen]
+		r.rdata = make([]byte, rdlen)
+		copy(r.rdata, s)
 	}
+	o.queueCallback(e, r)
+}
+
+// ReconfirmRecord asks the daemon to verify that a record is still valid,
+// such as when a connection to an address obtained from a query fails.
+// If the record is found to be stale the daemon will flush it from its cache
+// and operations which returned it will receive a remove callback.
+func ReconfirmRecord(interfaceIndex int, fullname string, rrtype, rrclass uint16, rdata []byte) error {
+	o := baseOp{interfaceIndex: interfaceIndex}
+	return reconfirmRecord(0, o.interfaceIndexC(), fullname, rrtype, rrclass, rdata)
 }
@@ -0,0 +1,43 @@
+package dnssd
+
+import (
+	"net"
+
+	"github.com/andrewtj/dnssd/rr"
+)
+
+// decodeSRV returns the port and target of SRV rdata.
+func decodeSRV(rdata []byte) (port int, target string, err error) {
+	srv, err := decodeSRVRecord(rdata)
+	if err != nil {
+		return 0, "", err
+	}
+	return int(srv.Port), srv.Target, nil
+}
+
+func decodeSRVRecord(rdata []byte) (*net.SRV, error) {
+	rd, err := rr.Decode(rr.TypeSRV, rdata)
+	if err != nil {
+		return nil, err
+	}
+	srv := rd.(*rr.SRV)
+	return &net.SRV{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: srv.Target}, nil
+}
+
+// decodeTXTStrings returns the character strings contained in TXT rdata.
+func decodeTXTStrings(rdata []byte) ([]string, error) {
+	rd, err := rr.Decode(rr.TypeTXT, rdata)
+	if err != nil {
+		return nil, err
+	}
+	return rd.(*rr.TXT).Txt, nil
+}
+
+// decodePTR returns the presentation format of the domain name in PTR rdata.
+func decodePTR(rdata []byte) (string, error) {
+	rd, err := rr.Decode(rr.TypePTR, rdata)
+	if err != nil {
+		return "", err
+	}
+	return rd.(*rr.PTR).Ptr, nil
+}
@@ -8,20 +8,34 @@ import (
 // RegisterCallbackFunc is called when a name is registered or deregistered in a given domain, or when an error occurs.
 type RegisterCallbackFunc func(op *RegisterOp, err error, add bool, name, serviceType, domain string)
 
+// ConflictResolver is called when a RegisterOp's name conflicts with that of
+// another service. It's passed the number of conflicts so far and the name
+// that conflicted, and returns the name to try next. Returning false gives up.
+type ConflictResolver func(attempt int, name string) (newName string, ok bool)
+
 // RegisterOp represents a service registration operation.
 type RegisterOp struct {
 	baseOp
-	name   string
-	stype  string
-	domain string
-	host   string
-	port   int
-	txt    struct {
+	name     string
+	stype    string
+	subtypes []string
+	domain   string
+	host     string
+	port     int
+	txt      struct {
 		l int
-		m map[string]string
+		r TXTRecord
 	}
 	callback RegisterCallbackFunc
 	seenAdd  bool
+	// regName is the name being registered when it differs from name.
+	regName   string
+	nameStore NameStore
+	conflict  struct {
+		resolver ConflictResolver
+		attempt  int
+		gen      int
+	}
 }
 
 // NewRegisterOp creates a new RegisterOp with the given parameters set.
@@ -61,6 +75,8 @@ func (o *RegisterOp) Name() string {
 }
 
 // SetName sets the name of the service. A service name can not exceed 63 bytes.
+// The name is normalized to NFC when registered, and truncated if it's too
+// long and validation is skipped.
 func (o *RegisterOp) SetName(n string) error {
 	o.m.Lock()
 	defer o.m.Unlock()
@@ -89,6 +105,33 @@ func (o *RegisterOp) SetType(s string) error {
 	return nil
 }
 
+// Subtypes returns the service subtypes associated with the op.
+func (o *RegisterOp) Subtypes() []string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return append([]string(nil), o.subtypes...)
+}
+
+// AddSubtype adds a subtype (eg: "_printer") under which the service will also be registered.
+// A subtype can not exceed 63 bytes or contain a period, comma or backslash.
+func (o *RegisterOp) AddSubtype(s string) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	if !validSubtype(s) {
+		return ErrBadSubtype
+	}
+	for _, sub := range o.subtypes {
+		if sub == s {
+			return nil
+		}
+	}
+	o.subtypes = append(o.subtypes, s)
+	return nil
+}
+
 // Domain returns the domain associated with the op.
 func (o *RegisterOp) Domain() string {
 	o.m.Lock()
@@ -96,14 +139,20 @@ func (o *RegisterOp) Domain() string {
 	return o.domain
 }
 
-// SetDomain sets the domain associated with the op.
+// SetDomain sets the domain associated with the op. An internationalized
+// unicast domain is converted to its IDNA ASCII form. If it can't be, an
+// error is returned and the op's domain is left unchanged.
 func (o *RegisterOp) SetDomain(s string) error {
 	o.m.Lock()
 	defer o.m.Unlock()
 	if o.started {
 		return ErrStarted
 	}
-	o.domain = s
+	d, err := domainToASCII(s)
+	if err != nil {
+		return &ValidationError{FieldDomain, s, ErrBadDomain}
+	}
+	o.domain = d
 	return nil
 }
 
@@ -143,6 +192,34 @@ func (o *RegisterOp) SetPort(p int) error {
 	return nil
 }
 
+// TXT returns a copy of the service's TXT record.
+func (o *RegisterOp) TXT() TXTRecord {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.txt.r.Copy()
+}
+
+// SetTXT replaces the service's TXT record.
+func (o *RegisterOp) SetTXT(r TXTRecord) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	for _, e := range r {
+		if err := e.check(); err != nil {
+			return err
+		}
+	}
+	l := r.len()
+	if l > 65535 {
+		return ErrTXTLen
+	}
+	o.txt.l = l
+	o.txt.r = r.Copy()
+	return nil
+}
+
 // SetTXTPair creates or updates a TXT string with the provided value.
 func (o *RegisterOp) SetTXTPair(key, value string) error {
 	o.m.Lock()
@@ -150,23 +227,21 @@ func (o *RegisterOp) SetTXTPair(key, value string) error {
 	if o.started {
 		return ErrStarted
 	}
-	if o.txt.m == nil {
-		o.txt.m = make(map[string]string)
-	}
-	slen := len(key) + len(value) + 2
-	if slen > 255 {
-		return ErrTXTStringLen
+	e := TXTEntry{key, []byte(value)}
+	if err := e.check(); err != nil {
+		return err
 	}
+	slen := 1 + e.len()
 	oldslen := 0
-	if oldvalue, exists := o.txt.m[key]; exists {
-		oldslen = len(key) + len(oldvalue) + 2
+	if i := o.txt.r.index(key); i >= 0 {
+		oldslen = 1 + o.txt.r[i].len()
 	}
 	newtlen := o.txt.l - oldslen + slen
 	if newtlen > 65535 {
 		return ErrTXTLen
 	}
 	o.txt.l = newtlen
-	o.txt.m[key] = value
+	o.txt.r.Set(key, e.Value)
 	return nil
 }
 
@@ -177,10 +252,8 @@ func (o *RegisterOp) DeleteTXTPair(key string) error {
 	if o.started {
 		return ErrStarted
 	}
-	if s, e := o.txt.m[key]; e {
-		o.txt.l = o.txt.l - len(key) - len(s) - 2
-		delete(o.txt.m, key)
-	}
+	o.txt.r.Delete(key)
+	o.txt.l = o.txt.r.len()
 	return nil
 }
 
@@ -215,6 +288,47 @@ func (o *RegisterOp) SetNoAutoRename(e bool) error {
 	return nil
 }
 
+// SetConflictResolver sets a function to choose a new name when the service's
+// name conflicts with that of another service. While set, the service is
+// registered as if NoAutoRename were set and is re-registered under the name
+// the function returns. If the function gives up the op's callback is invoked
+// with ErrNameConflict. The name eventually registered is reported to the
+// op's callback as usual. The function is called on the goroutine that
+// delivers callbacks, so no op's callbacks are delivered until it returns.
+func (o *RegisterOp) SetConflictResolver(f ConflictResolver) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.conflict.resolver = f
+	return nil
+}
+
+// SetNameStore sets a NameStore used to persist the name the service is
+// registered under when it differs from the name set on the op, such as
+// after it has been renamed due to a conflict. On Start a stored name is
+// registered in place of the op's name. Services registered with an empty
+// name, and so under the computer's name, aren't stored. Errors storing a
+// name don't affect the op and are reported to the Observer.
+func (o *RegisterOp) SetNameStore(s NameStore) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.nameStore = s
+	return nil
+}
+
+// registeringName returns the name most recently passed to the daemon.
+func (o *RegisterOp) registeringName() string {
+	if o.regName != "" {
+		return NormalizeInstanceName(o.regName)
+	}
+	return NormalizeInstanceName(o.name)
+}
+
 // Start begins advertising the service.
 func (o *RegisterOp) Start() error {
 	o.m.Lock()
@@ -223,9 +337,24 @@ func (o *RegisterOp) Start() error {
 		return ErrStarted
 	}
 	o.seenAdd = false
+	o.regName = ""
+	if o.nameStore != nil && o.name != "" {
+		name, err := o.nameStore.LookupName(NormalizeName(o.name), o.stype, o.domain)
+		if err != nil {
+			return err
+		}
+		o.regName = name
+	}
+	o.conflict.attempt = 0
+	o.conflict.gen++
 	if o.callback == nil {
 		return ErrMissingCallback
 	}
+	if !o.skipValidation {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
 	err := pollServer.startOp(o)
 	o.started = err == nil || err == ErrStarted
 	return err
@@ -234,19 +363,29 @@ func (o *RegisterOp) Start() error {
 func (o *RegisterOp) init(sharedref uintptr) (ref uintptr, err error) {
 	ref = sharedref
 	o.setFlag(_FlagsShareConnection, ref != 0)
-	txt := make([]byte, 0, o.txt.l)
-	for k, v := range o.txt.m {
-		s := k + "=" + v
-		txt = append(txt, byte(len(s)))
-		txt = append(txt, s...)
+	var txt []byte
+	if len(o.txt.r) > 0 {
+		if txt, err = MarshalTXTRecord(o.txt.r); err != nil {
+			return 0, err
+		}
+	}
+	// Subtypes are appended to the service type separated by commas, eg:
+	// "_http._tcp,_printer".
+	stype := o.stype
+	for _, sub := range o.subtypes {
+		stype += "," + sub
 	}
-	err = registerStart(&ref, o.flags, o.interfaceIndexC(), o.name, o.stype, o.domain, o.host, o.port, txt, unsafe.Pointer(o))
+	flags, name := o.flags, o.registeringName()
+	if o.conflict.resolver != nil {
+		flags |= _FlagsNoAutoRename
+	}
+	err = registerStart(&ref, flags, o.interfaceIndexC(), name, stype, o.domain, o.host, o.port, txt, unsafe.Pointer(o))
 	// Avahi's Bonjour compatibility layer doesn't substitute the system's
 	// name in place of an empty service name string.
-	if err == ErrBadParam && o.name == "" {
+	if err == ErrBadParam && name == "" {
 		ref = sharedref
 		hostname, _ := os.Hostname()
-		err = registerStart(&ref, o.flags, o.interfaceIndexC(), hostname, o.stype, o.domain, o.host, o.port, txt, unsafe.Pointer(o))
+		err = registerStart(&ref, flags, o.interfaceIndexC(), NormalizeInstanceName(hostname), stype, o.domain, o.host, o.port, txt, unsafe.Pointer(o))
 	}
 	if err != nil {
 		ref = 0
@@ -269,30 +408,87 @@ func (o *RegisterOp) handleError(e error) {
 	if !o.started {
 		return
 	}
+	if e == ErrNameConflict && o.conflict.resolver != nil {
+		// The op remains started while the resolver is consulted on the
+		// callback goroutine and the service is re-registered, so the
+		// Observer isn't told it stopped unless that fails.
+		pollServer.removePollOp(o)
+		name := o.registeringName()
+		if name == "" {
+			name, _ = os.Hostname()
+			name = NormalizeInstanceName(name)
+		}
+		attempt, gen := o.conflict.attempt+1, o.conflict.gen
+		queueCallback(func() { o.resolveConflict(gen, attempt, name) })
+		return
+	}
 	o.started = false
-	pollServer.removePollOp(o)
+	pollServer.failOp(o, e)
 	queueCallback(func() { o.callback(o, e, false, "", "", "") })
 }
 
+// resolveConflict re-registers the service under the name chosen by the
+// conflict resolver. It's run on the callback goroutine.
+func (o *RegisterOp) resolveConflict(gen, attempt int, name string) {
+	newName, ok := o.conflict.resolver(attempt, name)
+	o.m.Lock()
+	if !o.started || o.conflict.gen != gen {
+		// The op was stopped, and perhaps restarted, while the conflict
+		// was pending. Stop didn't find it polled, so report it here.
+		o.m.Unlock()
+		pollServer.opStopped(o)
+		return
+	}
+	var err error = ErrNameConflict
+	if ok {
+		o.regName, o.conflict.attempt = newName, attempt
+		o.seenAdd = false
+		err = pollServer.restartOp(o)
+	}
+	if err != nil {
+		o.started = false
+		pollServer.opError(o, err)
+	}
+	o.m.Unlock()
+	if err != nil {
+		o.callback(o, err, false, "", "", "")
+	}
+}
+
 func dnssdRegisterCallback(sdRef unsafe.Pointer, flags uint32, err int32, name, regtype, domain, ctx unsafe.Pointer) {
 	o := (*RegisterOp)(ctx)
 	if e := getError(err); e != nil {
 		o.handleError(e)
-	} else {
-		a := flags&_FlagsAdd != 0
-		// Avahi's Bonjour compatibility layer doesn't set kDNSServiceFlagsAdd,
-		// so if a remove callback occurs before an add has been seen, pretend
-		// it's an add. This should do the right-thing since Avahi only supports
-		// registration in ".local".
-		if !a && !o.seenAdd {
-			a = true
-		}
-		if a && !o.seenAdd {
-			o.seenAdd = a
-		}
-		n := cStringToString(name)
-		r := cStringToString(regtype)
-		d := cStringToString(domain)
-		queueCallback(func() { o.callback(o, e, a, n, r, d) })
+		return
+	}
+	o.handleResult(flags&_FlagsAdd != 0, cStringToString(name), cStringToString(regtype), cStringToString(domain))
+}
+
+// handleResult reports a registration or its removal. Like handleError it's
+// called while the poll server's internal lock is held.
+func (o *RegisterOp) handleResult(a bool, n, r, d string) {
+	pollServer.countResult(o)
+	// Avahi's Bonjour compatibility layer doesn't set kDNSServiceFlagsAdd,
+	// so if a remove callback occurs before an add has been seen, pretend
+	// it's an add. This should do the right-thing since Avahi only supports
+	// registration in ".local".
+	if !a && !o.seenAdd {
+		a = true
+	}
+	if a && !o.seenAdd {
+		o.seenAdd = a
+	}
+	if a && o.nameStore != nil && o.name != "" && n != o.registeringName() {
+		key, stype, domain, store := NormalizeName(o.name), o.stype, o.domain, o.nameStore
+		queueCallback(func() {
+			if err := store.StoreName(key, stype, domain, n); err != nil {
+				if obs := observer(); obs != nil {
+					obs.NameStoreError(err)
+				}
+			}
+			o.callback(o, nil, a, n, r, d)
+		})
+		return
 	}
+	queueCallback(func() { o.callback(o, nil, a, n, r, d) })
 }
@@ -1,20 +1,39 @@
 package dnssd
 
-import (
-	"bytes"
-	"unsafe"
-)
+import "unsafe"
 
 // ResolveCallbackFunc is called when a service is resolved or an error occurs.
-type ResolveCallbackFunc func(op *ResolveOp, err error, host string, port int, txt map[string]string)
-
-// ResolveOp represents an operation that resolves a service instance to a host, port and TXT map containing meta data.
+type ResolveCallbackFunc func(op *ResolveOp, err error, host string, port int, txt TXTRecord)
+
+// ResolveResult contains the details of a resolved service instance.
+type ResolveResult struct {
+	// InterfaceIndex is the interface the service was resolved on.
+	InterfaceIndex int
+	// FullName is the escaped full domain name of the service instance.
+	FullName string
+	// Host is the name of the host providing the service. Like FullName
+	// it's escaped and fully qualified, with a trailing dot, and can be
+	// passed to a QueryOp or Resolver to look up the host's addresses.
+	Host string
+	// Port is the port the service listens on, in host byte order.
+	Port int
+	// TXT is the service's TXT record. It's empty if the service has none.
+	TXT TXTRecord
+	// MoreComing indicates further results are immediately available.
+	MoreComing bool
+}
+
+// ResolveResultCallbackFunc is called when a service is resolved or an error occurs.
+type ResolveResultCallbackFunc func(op *ResolveOp, err error, r ResolveResult)
+
+// ResolveOp represents an operation that resolves a service instance to a host, port and TXT record containing meta data.
 type ResolveOp struct {
 	baseOp
 	name     string
 	stype    string
 	domain   string
 	callback ResolveCallbackFunc
+	rcb      ResolveResultCallbackFunc
 }
 
 // NewResolveOp creates a new ResolveOp with the associated parameters set.
@@ -24,7 +43,10 @@ func NewResolveOp(interfaceIndex int, name, serviceType, domain string, f Resolv
 	op.SetInterfaceIndex(interfaceIndex)
 	op.SetName(name)
 	op.SetType(serviceType)
-	op.SetDomain(domain)
+	if op.SetDomain(domain) != nil {
+		// Keep the domain as given so that Start reports it.
+		op.domain = domain
+	}
 	op.SetCallback(f)
 	return op
 }
@@ -78,14 +100,20 @@ func (o *ResolveOp) Domain() string {
 	return o.domain
 }
 
-// SetDomain sets the domain associated with the op.
+// SetDomain sets the domain associated with the op. An internationalized
+// unicast domain is converted to its IDNA ASCII form. If it can't be, an
+// error is returned and the op's domain is left unchanged.
 func (o *ResolveOp) SetDomain(s string) error {
 	o.m.Lock()
 	defer o.m.Unlock()
 	if o.started {
 		return ErrStarted
 	}
-	o.domain = s
+	d, err := domainToASCII(s)
+	if err != nil {
+		return &ValidationError{FieldDomain, s, ErrBadDomain}
+	}
+	o.domain = d
 	return nil
 }
 
@@ -100,6 +128,38 @@ func (o *ResolveOp) SetCallback(f ResolveCallbackFunc) error {
 	return nil
 }
 
+// ForceMulticast indicates whether the resolve will be performed using multicast only.
+func (o *ResolveOp) ForceMulticast() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.flags&_FlagsForceMulticast != 0
+}
+
+// SetForceMulticast sets whether the resolve will be performed using multicast only.
+// If set to true the resolve will be performed via multicast DNS even if the domain
+// would normally imply unicast DNS.
+func (o *ResolveOp) SetForceMulticast(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.setFlag(_FlagsForceMulticast, e)
+	return nil
+}
+
+// SetResultCallback sets a function to call with a ResolveResult when a service is resolved or an error occurs.
+// It may be set instead of or in addition to the function set with SetCallback.
+func (o *ResolveOp) SetResultCallback(f ResolveResultCallbackFunc) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.rcb = f
+	return nil
+}
+
 // Start begins the resolve operation. Resolve operations should be stopped as soon as they are no longer needed.
 func (o *ResolveOp) Start() error {
 	o.m.Lock()
@@ -107,9 +167,14 @@ func (o *ResolveOp) Start() error {
 	if o.started {
 		return ErrStarted
 	}
-	if o.callback == nil {
+	if o.callback == nil && o.rcb == nil {
 		return ErrMissingCallback
 	}
+	if !o.skipValidation {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
 	err := pollServer.startOp(o)
 	o.started = err == nil || err == ErrStarted
 	return err
@@ -140,8 +205,19 @@ func (o *ResolveOp) handleError(e error) {
 		return
 	}
 	o.started = false
-	pollServer.removePollOp(o)
-	queueCallback(func() { o.callback(o, e, "", 0, nil) })
+	pollServer.failOp(o, e)
+	o.queueCallback(e, ResolveResult{})
+}
+
+func (o *ResolveOp) queueCallback(e error, r ResolveResult) {
+	o.deliver(func() {
+		if o.callback != nil {
+			o.callback(o, e, r.Host, r.Port, r.TXT)
+		}
+		if o.rcb != nil {
+			o.rcb(o, e, r)
+		}
+	})
 }
 
 func dnssdResolveCallback(sdRef unsafe.Pointer, flags, interfaceIndex uint32, err int32, fullname, hosttarget unsafe.Pointer, port uint16, txtLen uint16, txtRecord, ctx unsafe.Pointer) {
@@ -149,30 +225,20 @@ func dnssdResolveCallback(sdRef unsafe.Pointer, flags, interfaceIndex uint32, er
 	if e := getError(err); e != nil {
 		o.handleError(e)
 	} else {
-		h := cStringToString(hosttarget)
-		p := int(port)
+		pollServer.countResult(o)
+		r := ResolveResult{
+			InterfaceIndex: interfaceIndexGo(interfaceIndex),
+			FullName:       cStringToString(fullname),
+			Host:           cStringToString(hosttarget),
+			Port:           int(port),
+			MoreComing:     flags&_FlagsMoreComing != 0,
+		}
 		var txtBytes []byte
 		if txtLen > 0 && txtRecord != nil {
 			txtBytes = (*[65535]byte)(txtRecord)[:txtLen]
 		}
-		txt := decodeTxt(txtBytes)
-		queueCallback(func() { o.callback(o, e, h, p, txt) })
-	}
-}
-
-func decodeTxt(txt []byte) map[string]string {
-	m := make(map[string]string)
-	for offset := 0; offset < len(txt); {
-		start, end := offset+1, offset+1+int(txt[offset])
-		if end <= len(txt) && start != end {
-			s := txt[start:end]
-			if i := bytes.IndexByte(s, '='); i > 0 {
-				m[string(s[:i])] = string(s[i+1:])
-			} else {
-				m[string(s)] = ""
-			}
-		}
-		offset = end
+		// Malformed trailing strings are dropped.
+		r.TXT, _ = UnmarshalTXTRecord(txtBytes)
+		o.queueCallback(nil, r)
 	}
-	return m
 }
@@ -0,0 +1,309 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Resource record types and class used internally.
+const (
+	rrTypeA    = 1
+	rrTypePTR  = 12
+	rrTypeTXT  = 16
+	rrTypeAAAA = 28
+	rrTypeSRV  = 33
+	rrClassIN  = 1
+)
+
+// Resolver looks up names using QueryOp, so that names in ".local" are
+// resolved via multicast DNS regardless of how the net package is configured.
+// Its methods have the same signatures as those of *net.Resolver.
+//
+// The zero value is ready to use and queries all interfaces. Unlike ops, its
+// methods may be called from within a callback.
+type Resolver struct {
+	// InterfaceIndex is the interface queries are performed on.
+	InterfaceIndex int
+}
+
+// LookupHost looks up the given host, returning a slice of its addresses.
+func (r *Resolver) LookupHost(ctx context.Context, host string) (addrs []string, err error) {
+	ips, err := r.LookupIPAddr(ctx, host)
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, err
+}
+
+// LookupIPAddr looks up host, returning its IPv4 and IPv6 addresses.
+// Link-local IPv6 addresses have their zone set to the name of the
+// interface they were found on.
+func (r *Resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip, zone := parseIPZone(host); ip != nil {
+		return []net.IPAddr{{IP: ip, Zone: zone}}, nil
+	}
+	results, err := r.lookupAddrs(ctx, host)
+	var addrs []net.IPAddr
+	for _, qr := range results {
+		addrs = append(addrs, ipAddr(qr.rdata, qr.interfaceIndex))
+	}
+	return addrs, err
+}
+
+// lookupAddrs queries for the A and AAAA records of host concurrently.
+func (r *Resolver) lookupAddrs(ctx context.Context, host string) ([]queryResult, error) {
+	type lookupResult struct {
+		results []queryResult
+		err     error
+	}
+	ch := make(chan lookupResult, 2)
+	for _, t := range []uint16{rrTypeA, rrTypeAAAA} {
+		go func(t uint16) {
+			results, err := r.lookup(ctx, host, t)
+			ch <- lookupResult{results, err}
+		}(t)
+	}
+	var results []queryResult
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		lr := <-ch
+		if lr.err != nil && firstErr == nil {
+			firstErr = lr.err
+		}
+		results = append(results, lr.results...)
+	}
+	if len(results) > 0 {
+		return results, nil
+	}
+	return nil, firstErr
+}
+
+// LookupSRV looks up the SRV records of the given service, protocol and domain name.
+// If service and proto are empty, name is looked up directly.
+// The records are sorted by priority and then by weight.
+func (r *Resolver) LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error) {
+	target := name
+	if service != "" || proto != "" {
+		target = "_" + service + "._" + proto + "." + name
+	}
+	results, err := r.lookup(ctx, target, rrTypeSRV)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, qr := range results {
+		srv, err := decodeSRVRecord(qr.rdata)
+		if err != nil {
+			return "", nil, r.error(target, err)
+		}
+		addrs = append(addrs, srv)
+		cname = qr.fullname
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if addrs[i].Priority != addrs[j].Priority {
+			return addrs[i].Priority < addrs[j].Priority
+		}
+		return addrs[i].Weight > addrs[j].Weight
+	})
+	return cname, addrs, nil
+}
+
+// LookupTXT returns the TXT records for the given domain name. As with
+// *net.Resolver, the strings of each record are concatenated.
+func (r *Resolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	results, err := r.lookup(ctx, name, rrTypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, qr := range results {
+		s, err := decodeTXTStrings(qr.rdata)
+		if err != nil {
+			return nil, r.error(name, err)
+		}
+		txts = append(txts, strings.Join(s, ""))
+	}
+	return txts, nil
+}
+
+// LookupAddr performs a reverse lookup for the given address, returning a
+// list of names mapping to that address.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) (names []string, err error) {
+	ip, _ := parseIPZone(addr)
+	if ip == nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	name := reverseName(ip)
+	results, err := r.lookup(ctx, name, rrTypePTR)
+	if err != nil {
+		return nil, err
+	}
+	for _, qr := range results {
+		n, err := decodePTR(qr.rdata)
+		if err != nil {
+			return nil, r.error(name, err)
+		}
+		names = append(names, n)
+	}
+	return names, nil
+}
+
+func (r *Resolver) error(name string, err error) error {
+	dnsErr := &net.DNSError{Err: err.Error(), Name: name}
+	switch err {
+	case ErrNoSuchRecord, ErrNoSuchName:
+		dnsErr.Err = "no such host"
+		dnsErr.IsNotFound = true
+	case ErrTimeout, context.DeadlineExceeded:
+		dnsErr.IsTimeout = true
+	case ErrServiceNotRunning, ErrTransient:
+		dnsErr.IsTemporary = true
+	}
+	return dnsErr
+}
+
+// lookup runs a query until a batch of answers or a negative answer has been
+// received, the daemon times out the query or ctx is done. The query's
+// callbacks are called directly so that lookup doesn't wait on the callback
+// goroutine, which may be the one calling it.
+func (r *Resolver) lookup(ctx context.Context, name string, rrtype uint16) ([]queryResult, error) {
+	type event struct {
+		err error
+		r   queryResult
+	}
+	var (
+		m      sync.Mutex
+		events []event
+	)
+	ready := make(chan struct{}, 1)
+	op := NewQueryOp(r.InterfaceIndex, name, rrtype, rrClassIN, nil)
+	op.direct = true
+	op.rcb = func(op *QueryOp, err error, r queryResult) {
+		m.Lock()
+		events = append(events, event{err, r})
+		m.Unlock()
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+	next := func() (event, bool) {
+		m.Lock()
+		defer m.Unlock()
+		if len(events) == 0 {
+			return event{}, false
+		}
+		e := events[0]
+		events = events[1:]
+		return e, true
+	}
+	op.SetReturnIntermediates(true)
+	op.SetTimeout(true)
+	if err := op.Start(); err != nil {
+		return nil, r.error(name, err)
+	}
+	defer op.Stop()
+	var results []queryResult
+	for {
+		e, ok := next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				if len(results) > 0 {
+					return results, nil
+				}
+				return nil, r.error(name, ctx.Err())
+			case <-ready:
+			}
+			continue
+		}
+		switch {
+		case e.err != nil && e.err != ErrNoSuchRecord:
+			if len(results) > 0 {
+				return results, nil
+			}
+			return nil, r.error(name, e.err)
+		case e.err == nil && e.r.rrtype == rrtype:
+			results = updateResults(results, e.r)
+		}
+		if e.r.moreComing {
+			continue
+		}
+		if len(results) > 0 {
+			return results, nil
+		}
+		if e.err == ErrNoSuchRecord {
+			return nil, r.error(name, e.err)
+		}
+	}
+}
+
+// updateResults adds or removes r from results.
+func updateResults(results []queryResult, r queryResult) []queryResult {
+	for i, x := range results {
+		if x.interfaceIndex == r.interfaceIndex && string(x.rdata) == string(r.rdata) {
+			if r.add {
+				return results
+			}
+			return append(results[:i:i], results[i+1:]...)
+		}
+	}
+	if r.add {
+		results = append(results, r)
+	}
+	return results
+}
+
+func parseIPZone(s string) (net.IP, string) {
+	zone := ""
+	if i := strings.LastIndexByte(s, '%'); i > 0 {
+		s, zone = s[:i], s[i+1:]
+	}
+	return net.ParseIP(s), zone
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for ip.
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.Itoa(int(ip4[3])) + "." + strconv.Itoa(int(ip4[2])) + "." +
+			strconv.Itoa(int(ip4[1])) + "." + strconv.Itoa(int(ip4[0])) + ".in-addr.arpa."
+	}
+	const hex = "0123456789abcdef"
+	b := make([]byte, 0, 64+len("ip6.arpa."))
+	for i := len(ip) - 1; i >= 0; i-- {
+		b = append(b, hex[ip[i]&0xf], '.', hex[ip[i]>>4], '.')
+	}
+	return string(append(b, "ip6.arpa."...))
+}
+
+// resolveInstance resolves a service instance, stopping the ResolveOp once
+// the first result has been received or ctx is done. Like lookup it may be
+// called from within a callback.
+func resolveInstance(ctx context.Context, interfaceIndex int, name, serviceType, domain string) (ResolveResult, error) {
+	type event struct {
+		err error
+		r   ResolveResult
+	}
+	ch := make(chan event, 1)
+	op := NewResolveOp(interfaceIndex, name, serviceType, domain, nil)
+	op.direct = true
+	op.SetResultCallback(func(op *ResolveOp, err error, r ResolveResult) {
+		select {
+		case ch <- event{err, r}:
+		default:
+		}
+	})
+	if err := op.Start(); err != nil {
+		return ResolveResult{}, err
+	}
+	defer op.Stop()
+	select {
+	case <-ctx.Done():
+		return ResolveResult{}, ctx.Err()
+	case e := <-ch:
+		return e.r, e.err
+	}
+}
@@ -0,0 +1,122 @@
+// Package grpcresolver adapts the dnssd resolver package to gRPC.
+//
+// Importing this package registers a resolver for the "dnssd" scheme. A target
+// of "dnssd:///_grpc._tcp" connects to all instances of _grpc._tcp in the
+// default domains. A domain can be given as the authority, as in
+// "dnssd://local/_grpc._tcp". Each instance's TXT record is attached to its
+// addresses and endpoint and can be retrieved with Metadata.
+package grpcresolver
+
+import (
+	"strings"
+
+	"github.com/andrewtj/dnssd"
+	dnssdresolver "github.com/andrewtj/dnssd/resolver"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the scheme handled by the builder.
+const Scheme = "dnssd"
+
+func init() {
+	resolver.Register(NewBuilder())
+}
+
+// NewBuilder returns a resolver.Builder for the "dnssd" scheme.
+func NewBuilder() resolver.Builder {
+	return builder{newSource: func() source { return &dnssdresolver.Resolver{} }}
+}
+
+// source is the part of dnssdresolver.Resolver a builder uses.
+type source interface {
+	Watch(serviceType, domain string) <-chan []dnssdresolver.Endpoint
+	Err() error
+	Close()
+}
+
+type builder struct {
+	newSource func() source
+}
+
+func (builder) Scheme() string { return Scheme }
+
+func (b builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	serviceType := strings.Trim(target.Endpoint(), "/")
+	r := b.newSource()
+	ch := r.Watch(serviceType, target.URL.Host)
+	go func() {
+		for eps := range ch {
+			cc.UpdateState(state(eps))
+		}
+		if err := r.Err(); err != nil {
+			cc.ReportError(err)
+		}
+	}()
+	return grpcResolver{r}, nil
+}
+
+type grpcResolver struct {
+	r source
+}
+
+func (grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (g grpcResolver) Close() { g.r.Close() }
+
+type metadataKey struct{}
+
+// metadata wraps a TXT record so that attributes can compare it.
+type metadata struct {
+	txt dnssd.TXTRecord
+}
+
+func (m metadata) Equal(o interface{}) bool {
+	om, ok := o.(metadata)
+	if !ok || len(m.txt) != len(om.txt) {
+		return false
+	}
+	for i, e := range m.txt {
+		oe := om.txt[i]
+		if e.Key != oe.Key || string(e.Value) != string(oe.Value) || (e.Value == nil) != (oe.Value == nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Metadata returns the TXT record of the service instance an address or
+// endpoint was resolved from.
+func Metadata(a *attributes.Attributes) (dnssd.TXTRecord, bool) {
+	m, ok := a.Value(metadataKey{}).(metadata)
+	return m.txt, ok
+}
+
+// state converts endpoints to a resolver state. An address is only given to
+// the first endpoint it appears in, and endpoints left without addresses are
+// dropped.
+func state(eps []dnssdresolver.Endpoint) resolver.State {
+	var s resolver.State
+	seen := make(map[string]bool)
+	for _, ep := range eps {
+		attr := attributes.New(metadataKey{}, metadata{ep.Metadata})
+		var addrs []resolver.Address
+		for _, a := range ep.Addresses() {
+			if seen[a] {
+				continue
+			}
+			seen[a] = true
+			addrs = append(addrs, resolver.Address{
+				Addr:       a,
+				ServerName: strings.TrimSuffix(ep.Host, "."),
+				Attributes: attr,
+			})
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		s.Addresses = append(s.Addresses, addrs...)
+		s.Endpoints = append(s.Endpoints, resolver.Endpoint{Addresses: addrs, Attributes: attr})
+	}
+	return s
+}
@@ -0,0 +1,190 @@
+package grpcresolver
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/andrewtj/dnssd"
+	dnssdresolver "github.com/andrewtj/dnssd/resolver"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSource struct {
+	ch          chan []dnssdresolver.Endpoint
+	err         error
+	serviceType string
+	domain      string
+	closed      bool
+}
+
+func (f *fakeSource) Watch(serviceType, domain string) <-chan []dnssdresolver.Endpoint {
+	f.serviceType, f.domain = serviceType, domain
+	return f.ch
+}
+
+func (f *fakeSource) Err() error { return f.err }
+
+func (f *fakeSource) Close() { f.closed = true }
+
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+	errs   chan error
+}
+
+func (c *fakeClientConn) UpdateState(s resolver.State) error {
+	c.states <- s
+	return nil
+}
+
+func (c *fakeClientConn) ReportError(err error) { c.errs <- err }
+
+var (
+	epA = dnssdresolver.Endpoint{
+		Instance: "a",
+		Host:     "a.local.",
+		Port:     1,
+		Addrs: []net.IPAddr{
+			{IP: net.IPv4(192, 0, 2, 1)},
+			{IP: net.ParseIP("fe80::1"), Zone: "en0"},
+		},
+		Metadata: dnssd.TXTRecord{{Key: "k", Value: []byte("v")}, {Key: "flag"}},
+	}
+	epB = dnssdresolver.Endpoint{Instance: "b", Host: "b.local.", Port: 2}
+)
+
+func addrs(s resolver.State) []string {
+	var a []string
+	for _, addr := range s.Addresses {
+		a = append(a, addr.Addr)
+	}
+	return a
+}
+
+func addrsOf(e resolver.Endpoint) []string {
+	var a []string
+	for _, addr := range e.Addresses {
+		a = append(a, addr.Addr)
+	}
+	return a
+}
+
+func TestState(t *testing.T) {
+	// c shares an address with a, and d has only addresses a has.
+	epC := dnssdresolver.Endpoint{
+		Instance: "c",
+		Host:     "c.local.",
+		Port:     1,
+		Addrs:    []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}, {IP: net.IPv4(192, 0, 2, 3)}},
+		Metadata: dnssd.TXTRecord{{Key: "k", Value: []byte("c")}},
+	}
+	epD := dnssdresolver.Endpoint{Instance: "d", Host: "d.local.", Port: 1, Addrs: epA.Addrs[:1]}
+	s := state([]dnssdresolver.Endpoint{epA, epB, epC, epD})
+	want := []string{"192.0.2.1:1", "[fe80::1%en0]:1", "b.local:2", "192.0.2.3:1"}
+	if a := addrs(s); !reflect.DeepEqual(a, want) {
+		t.Fatalf("Expected addresses %v, got %v", want, a)
+	}
+	if len(s.Endpoints) != 3 {
+		t.Fatalf("Expected 3 endpoints, got %d", len(s.Endpoints))
+	}
+	for i, ep := range []dnssdresolver.Endpoint{epA, epB, epC} {
+		e := s.Endpoints[i]
+		if txt, ok := Metadata(e.Attributes); !ok || !reflect.DeepEqual(txt, ep.Metadata) {
+			t.Fatalf("Endpoint %d: expected metadata %v, got %v, %v", i, ep.Metadata, txt, ok)
+		}
+		for _, a := range e.Addresses {
+			if txt, ok := Metadata(a.Attributes); !ok || !reflect.DeepEqual(txt, ep.Metadata) {
+				t.Fatalf("Address %s: expected metadata %v, got %v, %v", a.Addr, ep.Metadata, txt, ok)
+			}
+			if want := ep.Host[:len(ep.Host)-1]; a.ServerName != want {
+				t.Fatalf("Address %s: expected server name %q, got %q", a.Addr, want, a.ServerName)
+			}
+		}
+	}
+	if !reflect.DeepEqual(addrsOf(s.Endpoints[2]), []string{"192.0.2.3:1"}) {
+		t.Fatalf("Expected duplicate address dropped from endpoint, got %v", addrsOf(s.Endpoints[2]))
+	}
+	if s := state(nil); len(s.Addresses) != 0 || len(s.Endpoints) != 0 {
+		t.Fatalf("Expected empty state, got %+v", s)
+	}
+}
+
+func TestMetadataEqual(t *testing.T) {
+	m := metadata{dnssd.TXTRecord{{Key: "k", Value: []byte{}}}}
+	if !m.Equal(metadata{dnssd.TXTRecord{{Key: "k", Value: []byte{}}}}) {
+		t.Fatal("Expected equal metadata to be equal")
+	}
+	for _, o := range []interface{}{
+		metadata{dnssd.TXTRecord{{Key: "k"}}},
+		metadata{dnssd.TXTRecord{{Key: "k", Value: []byte("v")}}},
+		metadata{dnssd.TXTRecord{{Key: "j", Value: []byte{}}}},
+		metadata{},
+		"k=",
+	} {
+		if m.Equal(o) {
+			t.Fatalf("Expected %v not to equal %v", m, o)
+		}
+	}
+}
+
+func nextState(t *testing.T, cc *fakeClientConn) resolver.State {
+	select {
+	case s := <-cc.states:
+		return s
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for state")
+		panic("unreachable")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	src := &fakeSource{ch: make(chan []dnssdresolver.Endpoint)}
+	b := builder{newSource: func() source { return src }}
+	u, err := url.Parse("dnssd://local/_grpc._tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &fakeClientConn{states: make(chan resolver.State, 1), errs: make(chan error, 1)}
+	r, err := b.Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if src.serviceType != "_grpc._tcp" || src.domain != "local" {
+		t.Fatalf("Expected watch of _grpc._tcp in local, got %q in %q", src.serviceType, src.domain)
+	}
+
+	src.ch <- []dnssdresolver.Endpoint{epA, epB}
+	if s := nextState(t, cc); len(s.Endpoints) != 2 || len(s.Addresses) != 3 {
+		t.Fatalf("Expected 2 endpoints with 3 addresses, got %v", addrs(s))
+	}
+	// b is removed.
+	src.ch <- []dnssdresolver.Endpoint{epA}
+	want := []string{"192.0.2.1:1", "[fe80::1%en0]:1"}
+	if s := nextState(t, cc); len(s.Endpoints) != 1 || !reflect.DeepEqual(addrs(s), want) {
+		t.Fatalf("Expected 1 endpoint with %v, got %v", want, addrs(s))
+	}
+	// Everything is removed.
+	src.ch <- nil
+	if s := nextState(t, cc); len(s.Endpoints) != 0 || len(s.Addresses) != 0 {
+		t.Fatalf("Expected empty state, got %v", addrs(s))
+	}
+
+	src.err = errors.New("watch failed")
+	close(src.ch)
+	select {
+	case err := <-cc.errs:
+		if err != src.err {
+			t.Fatalf("Expected %v, got %v", src.err, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for error")
+	}
+	r.Close()
+	if !src.closed {
+		t.Fatal("Expected Close to close the source")
+	}
+}
@@ -0,0 +1,154 @@
+// Package resolver streams the endpoints of DNS-SD services as they come
+// and go. It's intended to drive client-side load balancers such as gRPC's
+// and has no dependencies beyond the dnssd package.
+package resolver
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andrewtj/dnssd"
+)
+
+// Endpoint is a resolved service instance.
+type Endpoint struct {
+	// Instance is the service instance name.
+	Instance       string
+	InterfaceIndex int
+	Host           string
+	Port           int
+	// Addrs contains the addresses of Host known so far.
+	Addrs []net.IPAddr
+	// Metadata contains the instance's TXT record.
+	Metadata dnssd.TXTRecord
+}
+
+// Addresses returns "host:port" strings for each of the endpoint's addresses,
+// or for its host name if no addresses are known.
+func (e Endpoint) Addresses() []string {
+	port := strconv.Itoa(e.Port)
+	if len(e.Addrs) == 0 {
+		return []string{net.JoinHostPort(strings.TrimSuffix(e.Host, "."), port)}
+	}
+	a := make([]string, len(e.Addrs))
+	for i, addr := range e.Addrs {
+		a[i] = net.JoinHostPort(addr.String(), port)
+	}
+	return a
+}
+
+// Resolver watches service types for changes.
+//
+// The zero value is ready to use and watches all interfaces.
+type Resolver struct {
+	// InterfaceIndex is the interface services are browsed and resolved on.
+	InterfaceIndex int
+
+	m       sync.Mutex
+	closed  bool
+	err     error
+	watches []*watch
+}
+
+type watch struct {
+	d      *dnssd.ServiceDirectory
+	ch     chan []Endpoint
+	closed bool
+}
+
+// Watch browses for instances of serviceType in domain, sending the complete
+// set of endpoints on the returned channel each time it changes. If the
+// receiver falls behind, only the most recent set is retained.
+//
+// The channel is closed when the Resolver is closed or an error occurs, in
+// which case the error is available from Err.
+func (r *Resolver) Watch(serviceType, domain string) <-chan []Endpoint {
+	w := &watch{ch: make(chan []Endpoint, 1)}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.closed {
+		close(w.ch)
+		return w.ch
+	}
+	w.d = dnssd.NewServiceDirectory(serviceType, func(d *dnssd.ServiceDirectory, err error, e dnssd.ServiceEvent) {
+		r.update(w, err)
+	})
+	w.d.SetDomain(domain)
+	w.d.SetInterfaceIndex(r.InterfaceIndex)
+	if err := w.d.Start(); err != nil {
+		r.setErr(err)
+		close(w.ch)
+		return w.ch
+	}
+	r.watches = append(r.watches, w)
+	return w.ch
+}
+
+func (r *Resolver) update(w *watch, err error) {
+	var eps []Endpoint
+	if err == nil {
+		for _, s := range w.d.Snapshot() {
+			eps = append(eps, Endpoint{
+				Instance:       s.Name,
+				InterfaceIndex: s.InterfaceIndex,
+				Host:           s.Host,
+				Port:           s.Port,
+				Addrs:          s.Addrs,
+				Metadata:       s.TXT,
+			})
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if w.closed {
+		return
+	}
+	if err != nil {
+		r.setErr(err)
+		r.closeWatch(w)
+		return
+	}
+	select {
+	case <-w.ch:
+	default:
+	}
+	w.ch <- eps
+}
+
+func (r *Resolver) setErr(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *Resolver) closeWatch(w *watch) {
+	w.closed = true
+	w.d.Stop()
+	close(w.ch)
+	for i, x := range r.watches {
+		if x == w {
+			r.watches = append(r.watches[:i:i], r.watches[i+1:]...)
+			break
+		}
+	}
+}
+
+// Err returns the first error that caused a watch to end.
+func (r *Resolver) Err() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.err
+}
+
+// Close stops all watches and closes their channels. Subsequent calls to
+// Watch return a closed channel.
+func (r *Resolver) Close() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.closed = true
+	for len(r.watches) > 0 {
+		r.closeWatch(r.watches[0])
+	}
+}
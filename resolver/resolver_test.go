@@ -0,0 +1,30 @@
+package resolver
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestEndpointAddresses(t *testing.T) {
+	e := Endpoint{Host: "host.local.", Port: 50051}
+	if a := e.Addresses(); !reflect.DeepEqual(a, []string{"host.local:50051"}) {
+		t.Fatalf("Unexpected addresses without Addrs: %v", a)
+	}
+	e.Addrs = []net.IPAddr{
+		{IP: net.IPv4(192, 0, 2, 1)},
+		{IP: net.ParseIP("fe80::1"), Zone: "en0"},
+	}
+	want := []string{"192.0.2.1:50051", "[fe80::1%en0]:50051"}
+	if a := e.Addresses(); !reflect.DeepEqual(a, want) {
+		t.Fatalf("Expected %v, got %v", want, a)
+	}
+}
+
+func TestWatchAfterClose(t *testing.T) {
+	var r Resolver
+	r.Close()
+	if _, ok := <-r.Watch("_grpc._tcp", ""); ok {
+		t.Fatal("Expected channel from closed Resolver to be closed")
+	}
+}
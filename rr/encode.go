@@ -0,0 +1,125 @@
+package rr
+
+import "errors"
+
+// Errors returned by Encode.
+var (
+	ErrLabelLen    = errors.New("label exceeds 63 bytes")
+	ErrNameLen     = errors.New("name exceeds 255 bytes")
+	ErrBadName     = errors.New("malformed name")
+	ErrStringLen   = errors.New("character string exceeds 255 bytes")
+	ErrBadAddr     = errors.New("address is not of the record's family")
+	ErrUnsupported = errors.New("encoding not supported for type")
+)
+
+// Encode returns the wire format of rd, suitable for the daemon's record
+// registration and update APIs. Domain names are given in presentation
+// format, may use the escapes \. \\ and \DDD, and are encoded uncompressed.
+// A name without a trailing dot is treated as fully qualified.
+func Encode(rd RData) ([]byte, error) {
+	switch r := rd.(type) {
+	case *A:
+		ip := r.IP.To4()
+		if ip == nil {
+			return nil, ErrBadAddr
+		}
+		return append([]byte(nil), ip...), nil
+	case *AAAA:
+		if len(r.IP) != 16 {
+			return nil, ErrBadAddr
+		}
+		return append([]byte(nil), r.IP...), nil
+	case *CNAME:
+		return encodeName(nil, r.Target)
+	case *PTR:
+		return encodeName(nil, r.Ptr)
+	case *SRV:
+		b := []byte{
+			byte(r.Priority >> 8), byte(r.Priority),
+			byte(r.Weight >> 8), byte(r.Weight),
+			byte(r.Port >> 8), byte(r.Port),
+		}
+		return encodeName(b, r.Target)
+	case *TXT:
+		if len(r.Txt) == 0 {
+			// A TXT record must contain at least one string.
+			return []byte{0}, nil
+		}
+		return encodeStrings(nil, r.Txt...)
+	case *HINFO:
+		return encodeStrings(nil, r.CPU, r.OS)
+	case *Unknown:
+		return append([]byte{}, r.Data...), nil
+	}
+	return nil, ErrUnsupported
+}
+
+// encodeName appends the uncompressed wire format of the presentation
+// format name s to b.
+func encodeName(b []byte, s string) ([]byte, error) {
+	start := len(b)
+	if s == "." || s == "" {
+		return append(b, 0), nil
+	}
+	var label []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '.':
+			if len(label) == 0 {
+				return nil, ErrBadName
+			}
+			if b = appendLabel(b, label); b == nil {
+				return nil, ErrLabelLen
+			}
+			label = label[:0]
+			continue
+		case c != '\\':
+		case i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]):
+			n := int(s[i+1]-'0')*100 + int(s[i+2]-'0')*10 + int(s[i+3]-'0')
+			if n > 255 {
+				return nil, ErrBadName
+			}
+			c = byte(n)
+			i += 3
+		case i+1 < len(s) && !isDigit(s[i+1]):
+			c = s[i+1]
+			i++
+		default:
+			return nil, ErrBadName
+		}
+		label = append(label, c)
+	}
+	if len(label) > 0 {
+		if b = appendLabel(b, label); b == nil {
+			return nil, ErrLabelLen
+		}
+	}
+	b = append(b, 0)
+	if len(b)-start > 255 {
+		return nil, ErrNameLen
+	}
+	return b, nil
+}
+
+func appendLabel(b, label []byte) []byte {
+	if len(label) > 63 {
+		return nil
+	}
+	return append(append(b, byte(len(label))), label...)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// encodeStrings appends each string to b as a character string.
+func encodeStrings(b []byte, s ...string) ([]byte, error) {
+	for _, str := range s {
+		if len(str) > 255 {
+			return nil, ErrStringLen
+		}
+		b = append(append(b, byte(len(str))), str...)
+	}
+	return b, nil
+}
@@ -0,0 +1,382 @@
+// Package rr decodes the resource record data returned by dnssd.QueryOp.
+//
+// The record types used by DNS Service Discovery are decoded into their own
+// types. Other types are returned in the generic form described by RFC 3597.
+// Domain names are expected to be uncompressed, as they are when returned by
+// the daemon.
+package rr
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Record types with their own RData implementations.
+const (
+	TypeA     uint16 = 1
+	TypeCNAME uint16 = 5
+	TypePTR   uint16 = 12
+	TypeHINFO uint16 = 13
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+	TypeSRV   uint16 = 33
+	TypeOPT   uint16 = 41
+	TypeNSEC  uint16 = 47
+)
+
+// ClassINET is the Internet class.
+const ClassINET uint16 = 1
+
+// ErrMalformed is returned when rdata can't be decoded as the given type.
+var ErrMalformed = errors.New("malformed rdata")
+
+var typeNames = map[uint16]string{
+	TypeA:     "A",
+	TypeCNAME: "CNAME",
+	TypePTR:   "PTR",
+	TypeHINFO: "HINFO",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeSRV:   "SRV",
+	TypeOPT:   "OPT",
+	TypeNSEC:  "NSEC",
+}
+
+// TypeString returns the mnemonic of a record type, or the RFC 3597 generic
+// form (eg: "TYPE65534") for types without one.
+func TypeString(t uint16) string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// RData is the decoded data of a resource record.
+type RData interface {
+	// Type returns the record type of the data.
+	Type() uint16
+	// String returns the data in presentation format.
+	String() string
+}
+
+// A is the data of an A record.
+type A struct {
+	IP net.IP
+}
+
+// AAAA is the data of an AAAA record.
+type AAAA struct {
+	IP net.IP
+}
+
+// CNAME is the data of a CNAME record.
+type CNAME struct {
+	Target string
+}
+
+// PTR is the data of a PTR record.
+type PTR struct {
+	Ptr string
+}
+
+// HINFO is the data of a HINFO record.
+type HINFO struct {
+	CPU string
+	OS  string
+}
+
+// TXT is the data of a TXT record. Each element is a character string.
+type TXT struct {
+	Txt []string
+}
+
+// SRV is the data of an SRV record.
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+// NSEC is the data of an NSEC record. mDNS uses NSEC records to assert
+// which types exist for a name.
+type NSEC struct {
+	NextDomain string
+	Types      []uint16
+}
+
+// Option is an EDNS(0) option.
+type Option struct {
+	Code uint16
+	Data []byte
+}
+
+// OPT is the data of an OPT pseudo-record.
+type OPT struct {
+	Options []Option
+}
+
+// Unknown is the data of a record of a type without its own RData
+// implementation, or that couldn't be decoded as such.
+type Unknown struct {
+	RRType uint16
+	Data   []byte
+}
+
+func (*A) Type() uint16     { return TypeA }
+func (*AAAA) Type() uint16  { return TypeAAAA }
+func (*CNAME) Type() uint16 { return TypeCNAME }
+func (*PTR) Type() uint16   { return TypePTR }
+func (*HINFO) Type() uint16 { return TypeHINFO }
+func (*TXT) Type() uint16   { return TypeTXT }
+func (*SRV) Type() uint16   { return TypeSRV }
+func (*NSEC) Type() uint16  { return TypeNSEC }
+func (*OPT) Type() uint16   { return TypeOPT }
+
+// Type returns the record type of the data.
+func (u *Unknown) Type() uint16 { return u.RRType }
+
+func (r *A) String() string     { return r.IP.String() }
+func (r *AAAA) String() string  { return r.IP.String() }
+func (r *CNAME) String() string { return r.Target }
+func (r *PTR) String() string   { return r.Ptr }
+
+func (r *HINFO) String() string {
+	return quote(r.CPU) + " " + quote(r.OS)
+}
+
+func (r *TXT) String() string {
+	s := make([]string, len(r.Txt))
+	for i, t := range r.Txt {
+		s[i] = quote(t)
+	}
+	return strings.Join(s, " ")
+}
+
+func (r *SRV) String() string {
+	return strconv.Itoa(int(r.Priority)) + " " + strconv.Itoa(int(r.Weight)) + " " +
+		strconv.Itoa(int(r.Port)) + " " + r.Target
+}
+
+func (r *NSEC) String() string {
+	s := r.NextDomain
+	for _, t := range r.Types {
+		s += " " + TypeString(t)
+	}
+	return s
+}
+
+// String returns each option as its code and hex encoded data, eg: "4:0011aabbccdd".
+func (r *OPT) String() string {
+	s := make([]string, len(r.Options))
+	for i, o := range r.Options {
+		s[i] = strconv.Itoa(int(o.Code)) + ":" + hexString(o.Data)
+	}
+	return strings.Join(s, " ")
+}
+
+// String returns the data in the generic form described by RFC 3597, eg: "\# 2 abcd".
+func (u *Unknown) String() string {
+	s := `\# ` + strconv.Itoa(len(u.Data))
+	if len(u.Data) > 0 {
+		s += " " + hexString(u.Data)
+	}
+	return s
+}
+
+// Decode decodes rdata of the given type. Types without their own RData
+// implementation are returned as *Unknown. ErrMalformed is returned if the
+// rdata isn't valid for its type.
+func Decode(rrtype uint16, rdata []byte) (RData, error) {
+	switch rrtype {
+	case TypeA:
+		if len(rdata) != net.IPv4len {
+			return nil, ErrMalformed
+		}
+		return &A{IP: net.IP(append([]byte(nil), rdata...))}, nil
+	case TypeAAAA:
+		if len(rdata) != net.IPv6len {
+			return nil, ErrMalformed
+		}
+		return &AAAA{IP: net.IP(append([]byte(nil), rdata...))}, nil
+	case TypeCNAME, TypePTR:
+		name, off, err := decodeName(rdata, 0)
+		if err != nil {
+			return nil, err
+		}
+		if off != len(rdata) {
+			return nil, ErrMalformed
+		}
+		if rrtype == TypeCNAME {
+			return &CNAME{Target: name}, nil
+		}
+		return &PTR{Ptr: name}, nil
+	case TypeHINFO:
+		s, err := decodeStrings(rdata)
+		if err != nil {
+			return nil, err
+		}
+		if len(s) != 2 {
+			return nil, ErrMalformed
+		}
+		return &HINFO{CPU: s[0], OS: s[1]}, nil
+	case TypeTXT:
+		s, err := decodeStrings(rdata)
+		if err != nil {
+			return nil, err
+		}
+		return &TXT{Txt: s}, nil
+	case TypeSRV:
+		if len(rdata) < 7 {
+			return nil, ErrMalformed
+		}
+		target, off, err := decodeName(rdata, 6)
+		if err != nil {
+			return nil, err
+		}
+		if off != len(rdata) {
+			return nil, ErrMalformed
+		}
+		return &SRV{
+			Priority: uint16(rdata[0])<<8 | uint16(rdata[1]),
+			Weight:   uint16(rdata[2])<<8 | uint16(rdata[3]),
+			Port:     uint16(rdata[4])<<8 | uint16(rdata[5]),
+			Target:   target,
+		}, nil
+	case TypeNSEC:
+		next, off, err := decodeName(rdata, 0)
+		if err != nil {
+			return nil, err
+		}
+		types, err := decodeTypeBitmap(rdata[off:])
+		if err != nil {
+			return nil, err
+		}
+		return &NSEC{NextDomain: next, Types: types}, nil
+	case TypeOPT:
+		var opt OPT
+		for off := 0; off < len(rdata); {
+			if off+4 > len(rdata) {
+				return nil, ErrMalformed
+			}
+			code := uint16(rdata[off])<<8 | uint16(rdata[off+1])
+			l := int(rdata[off+2])<<8 | int(rdata[off+3])
+			off += 4
+			if off+l > len(rdata) {
+				return nil, ErrMalformed
+			}
+			opt.Options = append(opt.Options, Option{Code: code, Data: append([]byte(nil), rdata[off:off+l]...)})
+			off += l
+		}
+		return &opt, nil
+	}
+	return &Unknown{RRType: rrtype, Data: append([]byte(nil), rdata...)}, nil
+}
+
+// decodeName decodes the uncompressed wire format domain name starting at
+// off, returning it in presentation format along with the offset following it.
+// As in the names the daemon returns, '.', '\', control characters and space
+// are escaped while UTF-8 is left as is. DEL is escaped too.
+func decodeName(b []byte, off int) (string, int, error) {
+	var s []byte
+	for wire := 0; off < len(b); {
+		l := int(b[off])
+		off++
+		if wire += l + 1; wire > 255 {
+			return "", 0, ErrMalformed
+		}
+		if l == 0 {
+			if len(s) == 0 {
+				return ".", off, nil
+			}
+			return string(s), off, nil
+		}
+		if l > 63 || off+l > len(b) {
+			return "", 0, ErrMalformed
+		}
+		for _, c := range b[off : off+l] {
+			switch {
+			case c == '.' || c == '\\':
+				s = append(s, '\\', c)
+			case c <= ' ' || c == 0x7f:
+				s = append(s, '\\', '0'+c/100, '0'+c/10%10, '0'+c%10)
+			default:
+				s = append(s, c)
+			}
+		}
+		s = append(s, '.')
+		off += l
+	}
+	return "", 0, ErrMalformed
+}
+
+// decodeStrings decodes a sequence of character strings.
+func decodeStrings(b []byte) ([]string, error) {
+	var s []string
+	for off := 0; off < len(b); {
+		l := int(b[off])
+		off++
+		if off+l > len(b) {
+			return nil, ErrMalformed
+		}
+		s = append(s, string(b[off:off+l]))
+		off += l
+	}
+	return s, nil
+}
+
+// decodeTypeBitmap decodes the type bitmap of an NSEC record as described in
+// RFC 4034 section 4.1.2.
+func decodeTypeBitmap(b []byte) ([]uint16, error) {
+	var types []uint16
+	last := -1
+	for off := 0; off < len(b); {
+		if off+2 > len(b) {
+			return nil, ErrMalformed
+		}
+		window, l := int(b[off]), int(b[off+1])
+		off += 2
+		if window <= last || l == 0 || l > 32 || off+l > len(b) {
+			return nil, ErrMalformed
+		}
+		last = window
+		for i, octet := range b[off : off+l] {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(0x80>>uint(bit)) != 0 {
+					types = append(types, uint16(window<<8|i*8+bit))
+				}
+			}
+		}
+		off += l
+	}
+	return types, nil
+}
+
+// quote returns s as a quoted character string, escaping quotes, backslashes
+// and non-printable bytes.
+func quote(s string) string {
+	b := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c < ' ' || c >= 0x7f:
+			b = append(b, '\\', '0'+c/100, '0'+c/10%10, '0'+c%10)
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+func hexString(b []byte) string {
+	const hex = "0123456789abcdef"
+	s := make([]byte, 0, len(b)*2)
+	for _, c := range b {
+		s = append(s, hex[c>>4], hex[c&0xf])
+	}
+	return string(s)
+}
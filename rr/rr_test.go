@@ -0,0 +1,141 @@
+package rr
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testName = []byte{4, 'h', '.', 's', 't', 5, 'l', 'o', 'c', 'a', 'l', 0}
+
+// rdataTests are shared by the decoding and encoding tests.
+var rdataTests = []struct {
+	rrtype uint16
+	rdata  []byte
+	want   RData
+	str    string
+}{
+	{TypeA, []byte{192, 0, 2, 1}, &A{IP: net.IP{192, 0, 2, 1}}, "192.0.2.1"},
+	{TypeAAAA, net.ParseIP("fe80::1"), &AAAA{IP: net.ParseIP("fe80::1")}, "fe80::1"},
+	{TypePTR, testName, &PTR{Ptr: `h\.st.local.`}, `h\.st.local.`},
+	{TypeCNAME, []byte{0}, &CNAME{Target: "."}, "."},
+	{TypeCNAME, []byte{5, 'B', 0xc3, 0xbc, 'r', 'o', 4, 'a', ' ', 0x7f, 'b', 0}, &CNAME{Target: "B\u00fcro.a\\032\\127b."}, "B\u00fcro.a\\032\\127b."},
+	{TypeSRV, append([]byte{0, 1, 0, 2, 0x1f, 0x90}, testName...), &SRV{1, 2, 8080, `h\.st.local.`}, `1 2 8080 h\.st.local.`},
+	{TypeSRV, []byte{0, 0, 0, 0, 0, 80, 5, 'B', 0xc3, 0xbc, 'r', 'o', 5, 'l', 'o', 'c', 'a', 'l', 0}, &SRV{0, 0, 80, "B\u00fcro.local."}, "0 0 80 B\u00fcro.local."},
+	{TypeTXT, []byte{3, 'a', '=', 'b', 0, 2, '"', 0xff}, &TXT{Txt: []string{"a=b", "", "\"\xff"}}, `"a=b" "" "\"\255"`},
+	{TypeHINFO, []byte{3, 'A', 'R', 'M', 5, 'L', 'i', 'n', 'u', 'x'}, &HINFO{CPU: "ARM", OS: "Linux"}, `"ARM" "Linux"`},
+	{TypeNSEC, append(append([]byte(nil), testName...), 0, 4, 0x40, 0, 0x80, 0x08, 1, 1, 0x80), &NSEC{NextDomain: `h\.st.local.`, Types: []uint16{TypeA, TypeTXT, TypeAAAA, 256}}, `h\.st.local. A TXT AAAA TYPE256`},
+	{TypeOPT, []byte{0, 4, 0, 2, 0xab, 0xcd}, &OPT{Options: []Option{{Code: 4, Data: []byte{0xab, 0xcd}}}}, "4:abcd"},
+	{65534, []byte{0xab, 0xcd}, &Unknown{RRType: 65534, Data: []byte{0xab, 0xcd}}, `\# 2 abcd`},
+	{65534, nil, &Unknown{RRType: 65534}, `\# 0`},
+}
+
+func TestDecode(t *testing.T) {
+	for _, c := range rdataTests {
+		rd, err := Decode(c.rrtype, c.rdata)
+		if err != nil {
+			t.Fatalf("Decode(%s, %v) returned error: %v", TypeString(c.rrtype), c.rdata, err)
+		}
+		if !reflect.DeepEqual(rd, c.want) {
+			t.Fatalf("Decode(%s, %v) returned %#v, expected %#v", TypeString(c.rrtype), c.rdata, rd, c.want)
+		}
+		if rd.Type() != c.rrtype {
+			t.Fatalf("Expected type %d, got %d", c.rrtype, rd.Type())
+		}
+		if s := rd.String(); s != c.str {
+			t.Fatalf("Expected %s to format as %q, got %q", TypeString(c.rrtype), c.str, s)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, c := range []struct {
+		rrtype uint16
+		rdata  []byte
+	}{
+		{TypeA, []byte{192, 0, 2}},
+		{TypeAAAA, []byte{192, 0, 2, 1}},
+		{TypePTR, []byte{1, 'a'}},
+		{TypePTR, []byte{0, 0}},
+		{TypePTR, []byte{64}},
+		{TypeSRV, []byte{0, 1, 0, 2, 0x1f, 0x90}},
+		{TypeTXT, []byte{3, 'a'}},
+		{TypeHINFO, []byte{1, 'a'}},
+		{TypeNSEC, []byte{0, 0, 33}},
+		{TypeNSEC, []byte{0, 1, 1, 0x40, 0, 1, 0x40}},
+		{TypeOPT, []byte{0, 4, 0, 2, 0xab}},
+	} {
+		if _, err := Decode(c.rrtype, c.rdata); err != ErrMalformed {
+			t.Fatalf("Expected ErrMalformed decoding %s %v, got: %v", TypeString(c.rrtype), c.rdata, err)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, c := range rdataTests {
+		b, err := Encode(c.want)
+		if err == ErrUnsupported && (c.rrtype == TypeNSEC || c.rrtype == TypeOPT) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Encode(%#v) returned error: %v", c.want, err)
+		}
+		if !bytes.Equal(b, c.rdata) {
+			t.Fatalf("Encode(%#v) returned %v, expected %v", c.want, b, c.rdata)
+		}
+		rd, err := Decode(c.rrtype, b)
+		if err != nil || rd.String() != c.str {
+			t.Fatalf("Decoding encoded %s returned %v, %v", TypeString(c.rrtype), rd, err)
+		}
+	}
+}
+
+func TestEncodeName(t *testing.T) {
+	for s, want := range map[string][]byte{
+		"":                  {0},
+		".":                 {0},
+		"local":             {5, 'l', 'o', 'c', 'a', 'l', 0},
+		`a\.b\\c\032.local`: {6, 'a', '.', 'b', '\\', 'c', ' ', 5, 'l', 'o', 'c', 'a', 'l', 0},
+	} {
+		if b, err := encodeName(nil, s); err != nil || !bytes.Equal(b, want) {
+			t.Fatalf("encodeName(%q) returned %v, %v, expected %v", s, b, err, want)
+		}
+	}
+	label63 := strings.Repeat("a", 63)
+	long := strings.Repeat(label63+".", 4)
+	for s, want := range map[string]error{
+		label63 + "a.local.": ErrLabelLen,
+		long:                 ErrNameLen,
+		"a..local.":          ErrBadName,
+		".local.":            ErrBadName,
+		`a\`:                 ErrBadName,
+		`a\25`:               ErrBadName,
+		`a\256`:              ErrBadName,
+	} {
+		if _, err := encodeName(nil, s); err != want {
+			t.Fatalf("Expected encodeName(%q) to return %v, got %v", s, want, err)
+		}
+	}
+	if _, err := encodeName(nil, strings.Repeat(label63+".", 3)+strings.Repeat("a", 61)); err != nil {
+		t.Fatalf("Unexpected error encoding 255 byte name: %v", err)
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	for _, c := range []struct {
+		rd   RData
+		want error
+	}{
+		{&A{IP: net.ParseIP("fe80::1")}, ErrBadAddr},
+		{&AAAA{IP: net.IP{192, 0, 2, 1}}, ErrBadAddr},
+		{&TXT{Txt: []string{strings.Repeat("a", 256)}}, ErrStringLen},
+		{&HINFO{CPU: strings.Repeat("a", 256)}, ErrStringLen},
+		{&NSEC{}, ErrUnsupported},
+	} {
+		if _, err := Encode(c.rd); err != c.want {
+			t.Fatalf("Expected Encode(%#v) to return %v, got %v", c.rd, c.want, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build go1.21
+
+package dnssd
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogObserver is an Observer that logs using the log/slog package. Errors
+// and connection failures are logged at LevelWarn, connections at LevelInfo
+// and everything else at LevelDebug. Callback deliveries and queue depth
+// aren't logged.
+type SlogObserver struct {
+	l *slog.Logger
+}
+
+// NewSlogObserver returns a SlogObserver that logs to l, or to
+// slog.Default() if l is nil.
+func NewSlogObserver(l *slog.Logger) *SlogObserver {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &SlogObserver{l: l}
+}
+
+func (o *SlogObserver) log(level slog.Level, msg string, args ...interface{}) {
+	o.l.Log(context.Background(), level, msg, args...)
+}
+
+// OpStarted implements Observer.
+func (o *SlogObserver) OpStarted(kind string) {
+	o.log(slog.LevelDebug, "dnssd op started", "kind", kind)
+}
+
+// OpStopped implements Observer.
+func (o *SlogObserver) OpStopped(kind string) {
+	o.log(slog.LevelDebug, "dnssd op stopped", "kind", kind)
+}
+
+// OpError implements Observer.
+func (o *SlogObserver) OpError(kind string, err error) {
+	o.log(slog.LevelWarn, "dnssd op failed", "kind", kind, "err", err)
+}
+
+// FirstResult implements Observer.
+func (o *SlogObserver) FirstResult(kind string, latency time.Duration) {
+	o.log(slog.LevelDebug, "dnssd op first result", "kind", kind, "latency", latency)
+}
+
+// CallbackDelivered implements Observer.
+func (o *SlogObserver) CallbackDelivered() {}
+
+// CallbackQueueDepth implements Observer.
+func (o *SlogObserver) CallbackQueueDepth(n int) {}
+
+// ConnectionEstablished implements Observer.
+func (o *SlogObserver) ConnectionEstablished() {
+	o.log(slog.LevelInfo, "dnssd connection established")
+}
+
+// ConnectionLost implements Observer.
+func (o *SlogObserver) ConnectionLost(err error) {
+	o.log(slog.LevelWarn, "dnssd connection lost", "err", err)
+}
+
+// NameStoreError implements Observer.
+func (o *SlogObserver) NameStoreError(err error) {
+	o.log(slog.LevelWarn, "dnssd name store failed", "err", err)
+}
@@ -0,0 +1,216 @@
+package dnssd
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ServiceTransport is an http.RoundTripper that sends requests addressed to
+// service instances to the host and port they resolve to.
+//
+// A request's host is treated as a service instance if it is of the form
+// "<instance>.<_service>.<_tcp|_udp>.<domain>", such as
+// "My%20Device._http._tcp.local". The instance name may be percent-encoded.
+// As net/url won't parse such a URL from a string, set the URL's Host field
+// directly or use ServiceURL. If the instance's TXT record has a "path" key,
+// its value is joined to the front of the request's path with a single slash
+// between them; a path of "/" has no effect. Other requests are passed to
+// Base unmodified.
+//
+// Resolutions are cached until a BrowseOp reports the instance has been
+// removed. Close stops the underlying operations and clears the cache.
+type ServiceTransport struct {
+	// Base is the RoundTripper used to perform requests.
+	// If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	m       sync.Mutex
+	cache   map[string]ResolveResult
+	browses map[string]*BrowseOp
+	// resolveFunc replaces resolve in tests.
+	resolveFunc func(req *http.Request, instance, serviceType, domain string) (ResolveResult, error)
+}
+
+// ServiceURL returns a URL addressing the given service instance.
+func ServiceURL(scheme, instance, serviceType, domain, path string) *url.URL {
+	host := url.PathEscape(instance) + "." + strings.TrimSuffix(serviceType, ".")
+	if domain = strings.TrimSuffix(domain, "."); domain != "" {
+		host += "." + domain
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: path}
+}
+
+// parseServiceHost splits a host into a service instance name, type and domain.
+func parseServiceHost(host string) (instance, serviceType, domain string, ok bool) {
+	host, err := url.PathUnescape(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", "", "", false
+	}
+	proto := -1
+	for _, p := range []string{"._tcp", "._udp"} {
+		for off := 0; ; {
+			i := strings.Index(host[off:], p)
+			if i < 0 {
+				break
+			}
+			i += off
+			if end := i + len(p); end == len(host) || host[end] == '.' {
+				proto = i
+				break
+			}
+			off = i + len(p)
+		}
+		if proto >= 0 {
+			break
+		}
+	}
+	if proto < 0 {
+		return "", "", "", false
+	}
+	i := strings.LastIndex(host[:proto], "._")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	end := proto + len("._tcp")
+	instance, serviceType = host[:i], host[i+1:end]
+	if end < len(host) {
+		domain = host[end+1:]
+	}
+	return instance, serviceType, domain, true
+}
+
+func (t *ServiceTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ServiceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	instance, serviceType, domain, ok := parseServiceHost(req.URL.Hostname())
+	if !ok {
+		return t.base().RoundTrip(req)
+	}
+	resolve := t.resolve
+	if t.resolveFunc != nil {
+		resolve = t.resolveFunc
+	}
+	res, err := resolve(req, instance, serviceType, domain)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	host := net.JoinHostPort(strings.TrimSuffix(res.Host, "."), strconv.Itoa(res.Port))
+	r.URL.Host = host
+	r.Host = host
+	if prefix, ok := res.TXT.Get("path"); ok {
+		p := string(prefix)
+		r.URL.Path = joinPath(p, r.URL.Path)
+		if r.URL.RawPath != "" {
+			r.URL.RawPath = joinPath((&url.URL{Path: p}).EscapedPath(), r.URL.RawPath)
+		}
+	}
+	return t.base().RoundTrip(r)
+}
+
+// joinPath prefixes path with prefix, leaving exactly one slash between them.
+// A prefix of "" or "/" leaves path unchanged.
+func joinPath(prefix, path string) string {
+	if strings.Trim(prefix, "/") == "" {
+		return path
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	if path == "" {
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// transportKey returns a cache key for a service instance that matches
+// regardless of case or trailing dots.
+func transportKey(name, serviceType, domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		domain = "local"
+	}
+	return instanceKey(name, strings.TrimSuffix(serviceType, "."), domain)
+}
+
+func (t *ServiceTransport) resolve(req *http.Request, instance, serviceType, domain string) (ResolveResult, error) {
+	key := transportKey(instance, serviceType, domain)
+	browseKey := key[strings.IndexByte(key, 0)+1:]
+	t.m.Lock()
+	if res, ok := t.cache[key]; ok {
+		t.m.Unlock()
+		return res, nil
+	}
+	if t.browses[browseKey] == nil {
+		op := NewBrowseOp(serviceType, nil)
+		if err := op.SetDomain(domain); err != nil {
+			t.m.Unlock()
+			return ResolveResult{}, err
+		}
+		op.SetCallback(func(op *BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+			t.m.Lock()
+			defer t.m.Unlock()
+			if t.browses[browseKey] != op {
+				return
+			}
+			if err != nil {
+				delete(t.browses, browseKey)
+				for k := range t.cache {
+					if strings.HasSuffix(k, "\x00"+browseKey) {
+						delete(t.cache, k)
+					}
+				}
+				return
+			}
+			if !add {
+				delete(t.cache, transportKey(name, serviceType, domain))
+			}
+		})
+		if err := op.Start(); err != nil {
+			t.m.Unlock()
+			return ResolveResult{}, err
+		}
+		if t.browses == nil {
+			t.browses = make(map[string]*BrowseOp)
+		}
+		t.browses[browseKey] = op
+	}
+	t.m.Unlock()
+	res, err := resolveInstance(req.Context(), InterfaceIndexAny, instance, serviceType, domain)
+	if err != nil {
+		return ResolveResult{}, err
+	}
+	t.m.Lock()
+	if t.browses[browseKey] != nil {
+		if t.cache == nil {
+			t.cache = make(map[string]ResolveResult)
+		}
+		t.cache[key] = res
+	}
+	t.m.Unlock()
+	return res, nil
+}
+
+// Close stops the operations used to monitor cached services and clears the cache.
+func (t *ServiceTransport) Close() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, op := range t.browses {
+		op.Stop()
+	}
+	t.browses = nil
+	t.cache = nil
+}
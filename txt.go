@@ -0,0 +1,173 @@
+package dnssd
+
+import (
+	"bytes"
+	"strings"
+)
+
+// TXTEntry is a single key-value pair from a TXT record.
+// A nil Value indicates the key is present without a value (eg: "key"),
+// whereas an empty non-nil Value indicates the key has an empty value (eg: "key=").
+type TXTEntry struct {
+	Key   string
+	Value []byte
+}
+
+// Bool reports whether the key is present without a value.
+func (e TXTEntry) Bool() bool { return e.Value == nil }
+
+func (e TXTEntry) len() int {
+	if e.Value == nil {
+		return len(e.Key)
+	}
+	return len(e.Key) + 1 + len(e.Value)
+}
+
+// TXTRecord is an ordered list of TXT record key-value pairs.
+// Keys are compared case-insensitively and, as per RFC 6763, only the first
+// occurrence of a key is considered when looking up a value.
+type TXTRecord []TXTEntry
+
+func (r TXTRecord) index(key string) int {
+	for i := range r {
+		if strings.EqualFold(r[i].Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Has reports whether the record contains the given key.
+func (r TXTRecord) Has(key string) bool {
+	return r.index(key) >= 0
+}
+
+// Get returns the value of the first entry matching key. If the key is
+// present without a value, Get returns a nil value and true.
+func (r TXTRecord) Get(key string) (value []byte, ok bool) {
+	if i := r.index(key); i >= 0 {
+		return r[i].Value, true
+	}
+	return nil, false
+}
+
+// Set replaces the value of the first entry matching key or appends a new
+// entry if the key isn't present. A nil value sets the key without a value.
+func (r *TXTRecord) Set(key string, value []byte) error {
+	e := TXTEntry{key, value}
+	if err := e.check(); err != nil {
+		return err
+	}
+	if i := r.index(key); i >= 0 {
+		(*r)[i] = e
+	} else {
+		*r = append(*r, e)
+	}
+	return nil
+}
+
+// Delete removes all entries matching key.
+func (r *TXTRecord) Delete(key string) {
+	s := (*r)[:0]
+	for _, e := range *r {
+		if !strings.EqualFold(e.Key, key) {
+			s = append(s, e)
+		}
+	}
+	*r = s
+}
+
+// Map returns the record's key-value pairs as a map. Keys without a value
+// map to an empty string and only the first occurrence of a key is included.
+func (r TXTRecord) Map() map[string]string {
+	m := make(map[string]string, len(r))
+	for i, e := range r {
+		if r.index(e.Key) == i {
+			m[e.Key] = string(e.Value)
+		}
+	}
+	return m
+}
+
+// Copy returns a deep copy of the record.
+func (r TXTRecord) Copy() TXTRecord {
+	if r == nil {
+		return nil
+	}
+	c := make(TXTRecord, len(r))
+	for i, e := range r {
+		c[i].Key = e.Key
+		if e.Value != nil {
+			c[i].Value = append([]byte{}, e.Value...)
+		}
+	}
+	return c
+}
+
+func (r TXTRecord) len() int {
+	l := 0
+	for _, e := range r {
+		l += 1 + e.len()
+	}
+	return l
+}
+
+func (e TXTEntry) check() error {
+	if e.Key == "" || strings.IndexByte(e.Key, '=') >= 0 {
+		return ErrTXTKey
+	}
+	if e.len() > 255 {
+		return ErrTXTStringLen
+	}
+	return nil
+}
+
+// MarshalTXTRecord returns the wire format of r. An empty record is encoded
+// as a single empty string as required by RFC 6763.
+func MarshalTXTRecord(r TXTRecord) ([]byte, error) {
+	if len(r) == 0 {
+		return []byte{0}, nil
+	}
+	l := 0
+	for _, e := range r {
+		if err := e.check(); err != nil {
+			return nil, err
+		}
+		l += 1 + e.len()
+	}
+	if l > 65535 {
+		return nil, ErrTXTLen
+	}
+	b := make([]byte, 0, l)
+	for _, e := range r {
+		b = append(b, byte(e.len()))
+		b = append(b, e.Key...)
+		if e.Value != nil {
+			b = append(b, '=')
+			b = append(b, e.Value...)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalTXTRecord decodes the wire format of a TXT record. Empty strings
+// and strings without a key are skipped. If a string's length exceeds the
+// remaining data, the entries decoded up to that point are returned along
+// with ErrTXTMalformed.
+func UnmarshalTXTRecord(b []byte) (TXTRecord, error) {
+	var r TXTRecord
+	for offset := 0; offset < len(b); {
+		start, end := offset+1, offset+1+int(b[offset])
+		if end > len(b) {
+			return r, ErrTXTMalformed
+		}
+		s := b[start:end]
+		offset = end
+		if i := bytes.IndexByte(s, '='); i > 0 {
+			r = append(r, TXTEntry{string(s[:i]), append([]byte{}, s[i+1:]...)})
+		} else if i < 0 && len(s) > 0 {
+			r = append(r, TXTEntry{Key: string(s)})
+		}
+	}
+	return r, nil
+}
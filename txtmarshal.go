@@ -0,0 +1,196 @@
+package dnssd
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// TXTFieldError is returned by MarshalTXT and UnmarshalTXT when a struct field can't be converted.
+type TXTFieldError struct {
+	Field string
+	Key   string
+	Err   error
+}
+
+func (e *TXTFieldError) Error() string {
+	return fmt.Sprintf("TXT key %q (field %s): %v", e.Key, e.Field, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *TXTFieldError) Unwrap() error { return e.Err }
+
+type txtField struct {
+	index     int
+	name      string
+	key       string
+	omitEmpty bool
+}
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func txtFields(t reflect.Type) []txtField {
+	var fields []txtField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		tag := sf.Tag.Get("txt")
+		if tag == "-" {
+			continue
+		}
+		f := txtField{index: i, name: sf.Name, key: sf.Name}
+		opts := strings.Split(tag, ",")
+		if opts[0] != "" {
+			f.key = opts[0]
+		}
+		for _, opt := range opts[1:] {
+			if opt == "omitempty" {
+				f.omitEmpty = true
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// MarshalTXT returns a TXT record containing the exported fields of v, which
+// must be a struct or a pointer to a struct. Fields are encoded in order using
+// the field name as the key unless overridden by a `txt:"key"` tag. A tag of
+// `txt:"-"` skips the field and the "omitempty" option skips zero values.
+//
+// Supported field types are string, []byte, signed and unsigned integers,
+// bool and types implementing encoding.TextMarshaler. A true bool is encoded
+// as a key without a value and a false bool is omitted.
+func MarshalTXT(v interface{}) (TXTRecord, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrTXTTarget
+	}
+	if !rv.CanAddr() {
+		// Make fields addressable so pointer receiver MarshalText methods are found.
+		c := reflect.New(rv.Type()).Elem()
+		c.Set(rv)
+		rv = c
+	}
+	var r TXTRecord
+	for _, f := range txtFields(rv.Type()) {
+		fv := rv.Field(f.index)
+		value, present, err := marshalTXTValue(fv, f.omitEmpty)
+		if err == nil && present {
+			err = r.Set(f.key, value)
+		}
+		if err != nil {
+			return nil, &TXTFieldError{f.name, f.key, err}
+		}
+	}
+	if r.len() > 65535 {
+		return nil, ErrTXTLen
+	}
+	return r, nil
+}
+
+func marshalTXTValue(v reflect.Value, omitEmpty bool) (value []byte, present bool, err error) {
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+		v = v.Addr()
+	}
+	if v.Type().Implements(textMarshalerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false, nil
+		}
+		if value, err = v.Interface().(encoding.TextMarshaler).MarshalText(); err != nil {
+			return nil, false, err
+		}
+		if value == nil {
+			value = []byte{}
+		}
+		return value, !omitEmpty || len(value) > 0, nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String()), !omitEmpty || v.Len() > 0, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt([]byte{}, v.Int(), 10), !omitEmpty || v.Int() != 0, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint([]byte{}, v.Uint(), 10), !omitEmpty || v.Uint() != 0, nil
+	case reflect.Bool:
+		return nil, v.Bool(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return append([]byte{}, v.Bytes()...), !omitEmpty || v.Len() > 0, nil
+		}
+	}
+	return nil, false, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// UnmarshalTXT stores values from txt in the struct pointed to by v using the
+// same field rules as MarshalTXT. Keys are matched case-insensitively and only
+// the first occurrence of a key is considered. Fields whose key is absent are
+// left unchanged, except for bools which are set to whether the key is present.
+func UnmarshalTXT(txt TXTRecord, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrTXTTarget
+	}
+	rv = rv.Elem()
+	for _, f := range txtFields(rv.Type()) {
+		value, present := txt.Get(f.key)
+		fv := rv.Field(f.index)
+		if !present && fv.Kind() != reflect.Bool {
+			continue
+		}
+		if err := unmarshalTXTValue(fv, value, present); err != nil {
+			return &TXTFieldError{f.name, f.key, err}
+		}
+	}
+	return nil
+}
+
+func unmarshalTXTValue(v reflect.Value, value []byte, present bool) error {
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(value)
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Implements(textUnmarshalerType) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v.Interface().(encoding.TextUnmarshaler).UnmarshalText(value)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(string(value))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(string(value), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(string(value), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Bool:
+		v.SetBool(present)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte{}, value...))
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported type %s", v.Type())
+}
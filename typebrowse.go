@@ -0,0 +1,205 @@
+package dnssd
+
+import (
+	"strings"
+	"sync"
+)
+
+// ServiceTypeEnumerationType is the pseudo service type browsed to enumerate
+// the service types present in a domain.
+const ServiceTypeEnumerationType = "_services._dns-sd._udp"
+
+// TypeBrowseCallbackFunc is called when an error occurs or a service type is lost or found.
+// serviceType is of the form "_type._proto".
+type TypeBrowseCallbackFunc func(b *TypeBrowser, err error, add bool, interfaceIndex int, serviceType, domain string)
+
+// TypeBrowser enumerates the service types present on the network.
+//
+// If an instance callback is set a BrowseOp is started for each service type
+// found and stopped once the type has been lost on every interface.
+type TypeBrowser struct {
+	m                sync.Mutex
+	interfaceIndex   int
+	domain           string
+	callback         TypeBrowseCallbackFunc
+	instanceCallback BrowseCallbackFunc
+	op               *BrowseOp
+	types            map[string]*browsedType
+}
+
+type browsedType struct {
+	ifaces map[int]bool
+	op     *BrowseOp
+}
+
+// NewTypeBrowser creates a new TypeBrowser with the given callback set.
+func NewTypeBrowser(f TypeBrowseCallbackFunc) *TypeBrowser {
+	return &TypeBrowser{callback: f}
+}
+
+// StartTypeBrowser returns the equivalent of calling NewTypeBrowser and Start().
+func StartTypeBrowser(f TypeBrowseCallbackFunc) (*TypeBrowser, error) {
+	b := NewTypeBrowser(f)
+	return b, b.Start()
+}
+
+// Domain returns the domain associated with the browser.
+func (b *TypeBrowser) Domain() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.domain
+}
+
+// SetDomain sets the domain associated with the browser.
+func (b *TypeBrowser) SetDomain(s string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.op != nil {
+		return ErrStarted
+	}
+	b.domain = s
+	return nil
+}
+
+// InterfaceIndex returns the interface index the browser is associated with.
+func (b *TypeBrowser) InterfaceIndex() int {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.interfaceIndex
+}
+
+// SetInterfaceIndex sets the interface index the browser is associated with.
+func (b *TypeBrowser) SetInterfaceIndex(i int) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.op != nil {
+		return ErrStarted
+	}
+	b.interfaceIndex = i
+	return nil
+}
+
+// SetInstanceCallback sets the callback of the BrowseOps started for each
+// service type found. A nil callback disables browsing for instances.
+func (b *TypeBrowser) SetInstanceCallback(f BrowseCallbackFunc) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.op != nil {
+		return ErrStarted
+	}
+	b.instanceCallback = f
+	return nil
+}
+
+// Active indicates whether the browser is active.
+func (b *TypeBrowser) Active() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.op != nil
+}
+
+// Start begins browsing for service types.
+func (b *TypeBrowser) Start() error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.op != nil {
+		return ErrStarted
+	}
+	if b.callback == nil && b.instanceCallback == nil {
+		return ErrMissingCallback
+	}
+	op := NewBrowseOp(ServiceTypeEnumerationType, b.browseCallback)
+	if err := op.SetDomain(b.domain); err != nil {
+		return err
+	}
+	op.SetInterfaceIndex(b.interfaceIndex)
+	b.types = make(map[string]*browsedType)
+	b.op = op
+	if err := op.Start(); err != nil {
+		b.op = nil
+		return err
+	}
+	return nil
+}
+
+// Stop stops the browser and any BrowseOps it started.
+func (b *TypeBrowser) Stop() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.stop()
+}
+
+func (b *TypeBrowser) stop() {
+	if b.op == nil {
+		return
+	}
+	b.op.Stop()
+	b.op = nil
+	for _, t := range b.types {
+		if t.op != nil {
+			t.op.Stop()
+		}
+	}
+	b.types = nil
+}
+
+// splitEnumeratedType normalises the arguments of a browse callback for
+// ServiceTypeEnumerationType. The daemon reports the first label of the
+// service type as the name and the protocol label followed by the domain as
+// the type, eg: "_http" and "_tcp.local.".
+func splitEnumeratedType(name, serviceType, domain string) (string, string) {
+	proto, rest := serviceType, ""
+	if i := strings.IndexByte(serviceType, '.'); i >= 0 {
+		proto, rest = serviceType[:i], serviceType[i+1:]
+	}
+	if rest == "" || rest == "." {
+		rest = domain
+	}
+	return name + "." + proto, rest
+}
+
+func (b *TypeBrowser) browseCallback(op *BrowseOp, err error, add bool, interfaceIndex int, name, serviceType, domain string) {
+	b.m.Lock()
+	if op != b.op {
+		b.m.Unlock()
+		return
+	}
+	if err != nil {
+		b.stop()
+		b.m.Unlock()
+		if b.callback != nil {
+			b.callback(b, err, false, 0, "", "")
+		}
+		return
+	}
+	stype, domain := splitEnumeratedType(name, serviceType, domain)
+	key := strings.ToLower(stype + "\x00" + domain)
+	t := b.types[key]
+	switch {
+	case add && t == nil:
+		t = &browsedType{ifaces: make(map[int]bool)}
+		if b.instanceCallback != nil {
+			t.op = NewBrowseOp(stype, b.instanceCallback)
+			t.op.SetInterfaceIndex(b.interfaceIndex)
+			if t.op.SetDomain(domain) != nil || t.op.Start() != nil {
+				t.op = nil
+			}
+		}
+		b.types[key] = t
+		fallthrough
+	case add:
+		t.ifaces[interfaceIndex] = true
+	case t != nil:
+		delete(t.ifaces, interfaceIndex)
+		if len(t.ifaces) == 0 {
+			if t.op != nil {
+				t.op.Stop()
+			}
+			delete(b.types, key)
+		}
+	}
+	b.m.Unlock()
+	if b.callback != nil {
+		b.callback(b, nil, add, interfaceIndex, stype, domain)
+	}
+}
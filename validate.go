@@ -0,0 +1,230 @@
+package dnssd
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andrewtj/dnssd/rr"
+)
+
+// Values of ValidationError.Field.
+const (
+	FieldInstanceName = "instance name"
+	FieldServiceType  = "service type"
+	FieldSubtype      = "subtype"
+	FieldDomain       = "domain"
+	FieldHost         = "host"
+	FieldPort         = "port"
+	FieldName         = "name"
+)
+
+// ValidationError is returned by Validate, and by Start unless validation is
+// skipped, when a parameter of an op is invalid.
+type ValidationError struct {
+	Field string // one of the Field constants
+	Value string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// SkipValidation indicates whether Start skips validating the op's parameters.
+func (o *baseOp) SkipValidation() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.skipValidation
+}
+
+// SetSkipValidation sets whether Start skips validating the op's parameters.
+// By default Start calls Validate and returns any error it reports, rather
+// than leaving the daemon to reject the parameters.
+func (o *baseOp) SetSkipValidation(e bool) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.started {
+		return ErrStarted
+	}
+	o.skipValidation = e
+	return nil
+}
+
+// validServiceName reports whether s is a service name as described by
+// RFC 6335 section 5.1, without the leading underscore.
+func validServiceName(s string) bool {
+	if len(s) == 0 || len(s) > 15 || s[0] == '-' || s[len(s)-1] == '-' || strings.Contains(s, "--") {
+		return false
+	}
+	letter := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letter = true
+		case c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return letter
+}
+
+// validServiceType reports whether s is of the form "_name._tcp" or
+// "_name._udp", optionally with a trailing dot. If sub is true a subtype may
+// precede it, as in "_printer._sub._http._tcp".
+func validServiceType(s string, sub bool) bool {
+	s = strings.TrimSuffix(s, ".")
+	if sub {
+		if i := strings.Index(s, "._sub."); i >= 0 {
+			if !validSubtype(s[:i]) {
+				return false
+			}
+			s = s[i+len("._sub."):]
+		}
+	}
+	labels := strings.Split(s, ".")
+	if len(labels) != 2 || !strings.HasPrefix(labels[0], "_") || !validServiceName(labels[0][1:]) {
+		return false
+	}
+	proto := strings.ToLower(labels[1])
+	return proto == "_tcp" || proto == "_udp"
+}
+
+// validInstanceName reports whether s is at most 63 bytes of UTF-8 without
+// control characters, as required by RFC 6763 section 4.1.1.
+func validInstanceName(s string) bool {
+	if len(s) > 63 || !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validDomainName reports whether s is a domain name in presentation format
+// with labels of at most 63 bytes and a total length of at most 255 bytes,
+// and that any internationalized unicast domain it ends in is valid IDNA.
+func validDomainName(s string) bool {
+	if _, err := rr.Encode(&rr.PTR{Ptr: s}); err != nil {
+		return false
+	}
+	_, err := domainToASCII(s)
+	return err == nil
+}
+
+func validateServiceType(s string, sub bool) error {
+	if !validServiceType(s, sub) {
+		return &ValidationError{FieldServiceType, s, ErrBadServiceType}
+	}
+	return nil
+}
+
+func validateInstanceName(s string, required bool) error {
+	if (required && s == "") || !validInstanceName(s) {
+		return &ValidationError{FieldInstanceName, s, ErrBadInstanceName}
+	}
+	return nil
+}
+
+func validateDomain(field, s string) error {
+	if s != "" && !validDomainName(s) {
+		return &ValidationError{field, s, ErrBadDomain}
+	}
+	return nil
+}
+
+// Validate checks the op's parameters. The name may be empty, the type may
+// include comma separated subtypes, and the port must be between 0 and 65535.
+func (o *RegisterOp) Validate() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.validate()
+}
+
+func (o *RegisterOp) validate() error {
+	if err := validateInstanceName(NormalizeName(o.name), false); err != nil {
+		return err
+	}
+	stype := o.stype
+	if i := strings.IndexByte(stype, ','); i >= 0 {
+		for _, sub := range strings.Split(stype[i+1:], ",") {
+			if !validSubtype(sub) {
+				return &ValidationError{FieldSubtype, sub, ErrBadSubtype}
+			}
+		}
+		stype = stype[:i]
+	}
+	if err := validateServiceType(stype, false); err != nil {
+		return err
+	}
+	if err := validateDomain(FieldDomain, o.domain); err != nil {
+		return err
+	}
+	if err := validateDomain(FieldHost, o.host); err != nil {
+		return err
+	}
+	if o.port < 0 || o.port > 65535 {
+		return &ValidationError{FieldPort, fmt.Sprint(o.port), ErrBadPort}
+	}
+	return nil
+}
+
+// Validate checks the op's parameters.
+func (o *BrowseOp) Validate() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.validate()
+}
+
+func (o *BrowseOp) validate() error {
+	if !strings.EqualFold(strings.TrimSuffix(o.stype, "."), ServiceTypeEnumerationType) {
+		if err := validateServiceType(o.stype, o.subtype == ""); err != nil {
+			return err
+		}
+	}
+	return validateDomain(FieldDomain, o.domain)
+}
+
+// Validate checks the op's parameters. Unlike RegisterOp the name may not be empty.
+func (o *ResolveOp) Validate() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.validate()
+}
+
+func (o *ResolveOp) validate() error {
+	if err := validateInstanceName(NormalizeName(o.name), true); err != nil {
+		return err
+	}
+	if err := validateServiceType(o.stype, false); err != nil {
+		return err
+	}
+	return validateDomain(FieldDomain, o.domain)
+}
+
+// Validate checks the op's parameters.
+func (o *QueryOp) Validate() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.validate()
+}
+
+func (o *QueryOp) validate() error {
+	if o.name == "" || !validDomainName(o.name) {
+		return &ValidationError{FieldName, o.name, ErrBadDomain}
+	}
+	return nil
+}
+
+// Validate checks the op's parameters. A DomainEnumOp has none that can be
+// invalid so it always returns nil.
+func (o *DomainEnumOp) Validate() error {
+	return nil
+}